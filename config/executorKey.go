@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/term"
+)
+
+var (
+	executorKeyMu sync.RWMutex
+	executorKey   *ecdsa.PrivateKey
+)
+
+// LoadExecutorKey 在启动时加载执行者私钥，之后只保存在内存中
+// 配置了 KEYSTORE_PATH 时从 go-ethereum 加密 keystore 文件解密，口令来自 KEYSTORE_PASSWORD_FILE、KEYSTORE_PASSWORD 或终端输入；
+// 否则兼容旧配置，读取明文 PRIVATE_KEY
+func LoadExecutorKey() error {
+	var key *ecdsa.PrivateKey
+
+	if keystorePath := os.Getenv("KEYSTORE_PATH"); keystorePath != "" {
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return err
+		}
+
+		decrypted, err := decryptKeystoreFile(keystorePath, passphrase)
+		if err != nil {
+			return err
+		}
+		key = decrypted
+	} else {
+		decoded, err := crypto.HexToECDSA(strings.TrimPrefix(os.Getenv("PRIVATE_KEY"), "0x"))
+		if err != nil {
+			return fmt.Errorf("error converting private key: %v", err)
+		}
+		key = decoded
+	}
+
+	// 私钥已加载到内存，清除环境变量中的明文副本
+	os.Unsetenv("PRIVATE_KEY")
+
+	executorKeyMu.Lock()
+	executorKey = key
+	executorKeyMu.Unlock()
+	return nil
+}
+
+// LoadAdditionalExecutorKeys 加载额外的执行者私钥，用于并行提交 bundle
+// EXECUTOR_KEYSTORE_PATHS 为逗号分隔的 keystore 文件，口令与主 keystore 相同；EXECUTOR_PRIVATE_KEYS 为逗号分隔的明文私钥
+func LoadAdditionalExecutorKeys() ([]*ecdsa.PrivateKey, error) {
+	var keys []*ecdsa.PrivateKey
+
+	if keystorePaths := os.Getenv("EXECUTOR_KEYSTORE_PATHS"); keystorePaths != "" {
+		passphrase, err := readPassphrase()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, keystorePath := range strings.Split(keystorePaths, ",") {
+			key, err := decryptKeystoreFile(strings.TrimSpace(keystorePath), passphrase)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	if privateKeys := os.Getenv("EXECUTOR_PRIVATE_KEYS"); privateKeys != "" {
+		for i, privateKey := range strings.Split(privateKeys, ",") {
+			key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(privateKey), "0x"))
+			if err != nil {
+				return nil, fmt.Errorf("error converting executor private key #%d: %v", i, err)
+			}
+			keys = append(keys, key)
+		}
+		os.Unsetenv("EXECUTOR_PRIVATE_KEYS")
+	}
+	return keys, nil
+}
+
+// ExecutorKey 返回启动时加载的执行者私钥
+func ExecutorKey() (*ecdsa.PrivateKey, error) {
+	executorKeyMu.RLock()
+	defer executorKeyMu.RUnlock()
+
+	if executorKey == nil {
+		return nil, errors.New("executor key not loaded")
+	}
+	return executorKey, nil
+}
+
+// decryptKeystoreFile 读取并解密 go-ethereum 加密 keystore 文件
+func decryptKeystoreFile(keystorePath, passphrase string) (*ecdsa.PrivateKey, error) {
+	keyJSON, err := os.ReadFile(keystorePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keystore file: %v", err)
+	}
+
+	decrypted, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting keystore %s: %v", keystorePath, err)
+	}
+	return decrypted.PrivateKey, nil
+}
+
+// readPasswordFile 读取口令文件，去掉结尾的换行
+func readPasswordFile(passwordFile string) (string, error) {
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading keystore password file: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var (
+	passphraseMu     sync.Mutex
+	cachedPassphrase *string
+)
+
+// readPassphrase 返回 keystore 口令，整个进程只读取一次，主 keystore、EXECUTOR_KEYSTORE_PATHS 和 paymaster keystore 共用
+// 依次使用 KEYSTORE_PASSWORD_FILE、KEYSTORE_PASSWORD（读取后从环境变量中清除），都未配置时在终端提示输入
+func readPassphrase() (string, error) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+
+	if cachedPassphrase != nil {
+		return *cachedPassphrase, nil
+	}
+
+	passphrase, err := loadPassphrase()
+	if err != nil {
+		return "", err
+	}
+	cachedPassphrase = &passphrase
+	return passphrase, nil
+}
+
+// loadPassphrase 从口令文件、环境变量或终端读取口令
+func loadPassphrase() (string, error) {
+	if passwordFile := os.Getenv("KEYSTORE_PASSWORD_FILE"); passwordFile != "" {
+		return readPasswordFile(passwordFile)
+	}
+	if password, ok := os.LookupEnv("KEYSTORE_PASSWORD"); ok {
+		os.Unsetenv("KEYSTORE_PASSWORD")
+		return password, nil
+	}
+
+	// 终端下关闭回显读取；非终端（例如通过管道传入）时直接读取一行
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Keystore passphrase: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("error reading keystore passphrase: %v", err)
+		}
+		return string(password), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("error reading keystore passphrase: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// LoadPaymasterKey 读取 verifying paymaster 的签名私钥，未配置时返回 nil
+// 配置了 PAYMASTER_KEYSTORE_PATH 时从加密 keystore 文件解密，口令来自 PAYMASTER_KEYSTORE_PASSWORD_FILE，未配置时与执行者 keystore 的口令相同；
+// 否则兼容旧配置，读取明文 PAYMASTER_PRIVATE_KEY
+func LoadPaymasterKey() (*ecdsa.PrivateKey, error) {
+	// 私钥加载到内存后清除环境变量中的明文副本
+	defer os.Unsetenv("PAYMASTER_PRIVATE_KEY")
+
+	if keystorePath := os.Getenv("PAYMASTER_KEYSTORE_PATH"); keystorePath != "" {
+		var passphrase string
+		var err error
+		if passwordFile := os.Getenv("PAYMASTER_KEYSTORE_PASSWORD_FILE"); passwordFile != "" {
+			passphrase, err = readPasswordFile(passwordFile)
+		} else {
+			passphrase, err = readPassphrase()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decryptKeystoreFile(keystorePath, passphrase)
+	}
+
+	hexKey := os.Getenv("PAYMASTER_PRIVATE_KEY")
+	if hexKey == "" {
+		return nil, nil
+	}
+
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("error converting paymaster private key: %v", err)
+	}
+	return key, nil
+}
@@ -4,219 +4,331 @@ package controllers
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"math/big"
 	"os"
+	"sync"
+	"time"
 
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 const (
 	publicKeyOracleAddress = "0x57A9Edbb9fF61EFFB33537994f7F0E1fabBaA282" // 更新为正确的 PublicKeyOracle 合约地址
+	ownershipTransferTTL   = 5 * time.Minute                              // 所有权转移确认令牌的有效期
 )
 
 type PublicKeyOracleController struct {
 	Client *ethclient.Client
+	Nonces *NonceManager
+
+	oracleAbi abi.ABI
+
+	mu               sync.Mutex
+	pendingTransfers map[string]pendingOwnershipTransfer
+}
+
+// ErrInvalidConfirmationToken 所有权转移确认令牌不存在或已过期
+var ErrInvalidConfirmationToken = errors.New("invalid or expired confirmation token")
+
+// pendingOwnershipTransfer 等待二次确认的所有权转移请求
+type pendingOwnershipTransfer struct {
+	NewOwner  common.Address
+	ExpiresAt time.Time
 }
 
 // NewPublicKeyOracleController 创建一个新的 PublicKeyOracleController 实例
-func NewPublicKeyOracleController() (*PublicKeyOracleController, error) {
+// 预言机交易与 bundle 使用同一个执行者私钥，nonces 需要是打包路径使用的同一个 NonceManager
+func NewPublicKeyOracleController(nonces *NonceManager) (*PublicKeyOracleController, error) {
 	rpcURL := os.Getenv("RPC_URL") // 从环境变量中读取 RPC URL
 
 	client, err := ethclient.Dial(rpcURL)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to connect to the Ethereum client: %w", err)
 	}
+	oracleAbi, err := loadPublicKeyOracleABI()
+	if err != nil {
+		return nil, err
+	}
 	return &PublicKeyOracleController{
-		Client: client,
+		Client:           client,
+		Nonces:           nonces,
+		oracleAbi:        oracleAbi,
+		pendingTransfers: make(map[string]pendingOwnershipTransfer),
 	}, nil
 }
 
 // SetPublicKey 调用合约的 setPublicKey 方法，设置公钥信息
 func (ctrl *PublicKeyOracleController) SetPublicKey(domain, selector string, modulus, exponent []byte) (string, error) {
-	privateKey := os.Getenv("PRIVATE_KEY")
-	abiPath := os.Getenv("PublicKeyOracle_ABI") // 合约 ABI 文件路径
-
-	// 将私钥字符串转换为 ECDSA 私钥
-	privateKeyECDSA, err := crypto.HexToECDSA(privateKey)
+	results, errs, err := ctrl.setPublicKeys([]models.PublicKeyEntry{{Domain: domain, Selector: selector, Modulus: modulus, Exponent: exponent}})
 	if err != nil {
-		return "", fmt.Errorf("error converting private key: %v", err)
-	}
-
-	publicKey := privateKeyECDSA.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return "", fmt.Errorf("error asserting type of public key")
+		return "", err
 	}
-
-	// 从公钥推导出以太坊地址
-	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-
-	// 读取并解析 ABI 文件
-	abiData, err := os.ReadFile(abiPath)
-	if err != nil {
-		return "", fmt.Errorf("error reading ABI file: %v", err)
+	// 原样返回单条公钥的错误，保留 BundlerError 的错误码
+	if errs[0] != nil {
+		return "", errs[0]
 	}
+	return results[0].TransactionHash, nil
+}
 
-	var contractAbi abi.ABI
-	if err := contractAbi.UnmarshalJSON(abiData); err != nil {
-		return "", fmt.Errorf("error parsing ABI: %v", err)
-	}
+// SetPublicKeys 批量调用 setPublicKey，每条公钥一笔交易，nonce 由本地 NonceManager 连续分配
+// 合约的 setPublicKey 是 onlyOwner，无法通过 Multicall 合约转发，所以按顺序发送
+func (ctrl *PublicKeyOracleController) SetPublicKeys(entries []models.PublicKeyEntry) ([]models.PublicKeyResult, error) {
+	results, _, err := ctrl.setPublicKeys(entries)
+	return results, err
+}
 
-	// 使用 ABI 打包数据以调用 setPublicKey 方法
-	data, err := contractAbi.Pack("setPublicKey", domain, selector, modulus, exponent)
+// setPublicKeys 发送批量写入交易，同时返回每条公钥的原始错误，结果中只保留错误信息和错误码
+func (ctrl *PublicKeyOracleController) setPublicKeys(entries []models.PublicKeyEntry) ([]models.PublicKeyResult, []error, error) {
+	// 使用启动时加载的执行者签名器
+	signer, err := currentSigner()
 	if err != nil {
-		return "", fmt.Errorf("error packing data: %v", err)
+		return nil, nil, err
 	}
+	fromAddress := signer.Address()
 
-	// 获取账户的当前 nonce
-	nonce, err := ctrl.Client.PendingNonceAt(context.Background(), fromAddress)
+	// 获取建议的 gas price
+	gasPrice, err := ctrl.Client.SuggestGasPrice(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("error getting nonce: %v", err)
+		return nil, nil, fmt.Errorf("error getting gas price: %v", err)
 	}
 
-	// 获取建议的 gas price
-	gasPrice, err := ctrl.Client.SuggestGasPrice(context.Background())
+	// 获取区块链的 chain ID
+	chainID, err := ctrl.Client.NetworkID(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("error getting gas price: %v", err)
+		return nil, nil, fmt.Errorf("error getting network ID: %v", err)
 	}
 
 	// 计算发送交易需要的 gas limit
 	gasLimit := uint64(200000) // 根据实际情况调整
-
-	// 创建交易对象
-	value := big.NewInt(0)
 	toAddress := common.HexToAddress(publicKeyOracleAddress)
-	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, data)
 
-	// 获取区块链的 chain ID
-	chainID, err := ctrl.Client.NetworkID(context.Background())
-	if err != nil {
-		return "", fmt.Errorf("error getting network ID: %v", err)
+	results := make([]models.PublicKeyResult, len(entries))
+	errs := make([]error, len(entries))
+	fail := func(i int, err error) {
+		errs[i] = err
+		results[i].Error = err.Error()
+		results[i].Code = AsBundlerError(err).Code
+	}
+	for i, entry := range entries {
+		results[i] = models.PublicKeyResult{Domain: entry.Domain, Selector: entry.Selector}
+
+		// 校验公钥，避免写入会导致链上 DKIM 验签失败的数据
+		modulus, exponent, err := ResolveRSAKey(entry)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+
+		// 使用 ABI 打包数据以调用 setPublicKey 方法
+		data, err := ctrl.oracleAbi.Pack("setPublicKey", entry.Domain, entry.Selector, modulus, exponent)
+		if err != nil {
+			fail(i, fmt.Errorf("error packing data: %v", err))
+			continue
+		}
+
+		// 从本地 NonceManager 获取连续的 nonce
+		nonce, err := ctrl.Nonces.Next(fromAddress)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+
+		// 创建并签署交易
+		tx := types.NewTransaction(nonce, toAddress, big.NewInt(0), gasLimit, gasPrice, data)
+		signedTx, err := signer.SignTx(tx, chainID)
+		if err != nil {
+			ctrl.Nonces.Release(fromAddress, nonce)
+			fail(i, fmt.Errorf("error signing transaction: %v", err))
+			continue
+		}
+
+		// 发送交易到区块链，失败时归还 nonce，避免后续交易出现空洞
+		if err := ctrl.Client.SendTransaction(context.Background(), signedTx); err != nil {
+			ctrl.Nonces.Release(fromAddress, nonce)
+			fail(i, fmt.Errorf("error sending transaction: %v", err))
+			continue
+		}
+		ctrl.Nonces.Sent(fromAddress, nonce)
+
+		// 输出交易哈希
+		fmt.Printf("SetPublicKey transaction sent with hash: %s\n", signedTx.Hash().Hex())
+		results[i].TransactionHash = signedTx.Hash().Hex()
+		results[i].Nonce = nonce
 	}
+	return results, errs, nil
+}
 
-	// 签署交易
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKeyECDSA)
+// GetRSAKey 通过 eth_call 调用合约的 getRSAKey 只读方法，获取公钥信息
+func (ctrl *PublicKeyOracleController) GetRSAKey(domain, selector string) ([]byte, []byte, error) {
+	// 构造调用数据
+	callData, err := ctrl.oracleAbi.Pack("getRSAKey", domain, selector)
 	if err != nil {
-		return "", fmt.Errorf("error signing transaction: %v", err)
+		return nil, nil, fmt.Errorf("error packing data: %v", err)
 	}
 
-	// 发送交易到区块链
-	err = ctrl.Client.SendTransaction(context.Background(), signedTx)
+	// view 方法不需要签名和 gas，直接 eth_call
+	toAddress := common.HexToAddress(publicKeyOracleAddress)
+	result, err := ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: callData}, nil)
 	if err != nil {
-		return "", fmt.Errorf("error sending transaction: %v", err)
+		return nil, nil, fmt.Errorf("error calling getRSAKey: %v", err)
 	}
 
-	// 输出交易哈希
-	fmt.Printf("SetPublicKey transaction sent with hash: %s\n", signedTx.Hash().Hex())
-	return signedTx.Hash().Hex(), nil
+	// 解析返回的 (bytes modulus, bytes exponent)
+	var key struct {
+		Modulus  []byte
+		Exponent []byte
+	}
+	if err := ctrl.oracleAbi.UnpackIntoInterface(&key, "getRSAKey", result); err != nil {
+		return nil, nil, fmt.Errorf("error unpacking result: %v", err)
+	}
+
+	return key.Modulus, key.Exponent, nil
 }
 
-// GetRSAKey 方法调用合约的 getRSAKey 方法，获取公钥信息
-func (ctrl *PublicKeyOracleController) GetRSAKey(domain, selector string) (string, []byte, []byte, error) {
-	// 读取合约 ABI 文件路径
-	abiPath := os.Getenv("PublicKeyOracle_ABI")
-	abiData, err := os.ReadFile(abiPath)
+// Owner 调用合约的 owner 方法，获取预言机当前的所有者
+func (ctrl *PublicKeyOracleController) Owner() (common.Address, error) {
+	data, err := ctrl.oracleAbi.Pack("owner")
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("error reading ABI file: %v", err)
+		return common.Address{}, fmt.Errorf("error packing data: %v", err)
 	}
 
-	var contractAbi abi.ABI
-	if err := json.Unmarshal(abiData, &contractAbi); err != nil {
-		return "", nil, nil, fmt.Errorf("error parsing ABI: %v", err)
+	toAddress := common.HexToAddress(publicKeyOracleAddress)
+	result, err := ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: data}, nil)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("error calling owner: %v", err)
 	}
 
-	// 获取当前账户的 nonce
-	privateKey := os.Getenv("PRIVATE_KEY")
-	privateKeyECDSA, err := crypto.HexToECDSA(privateKey)
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("error converting private key: %v", err)
+	var owner common.Address
+	if err := ctrl.oracleAbi.UnpackIntoInterface(&owner, "owner", result); err != nil {
+		return common.Address{}, fmt.Errorf("error unpacking result: %v", err)
 	}
+	return owner, nil
+}
 
-	fromAddress := crypto.PubkeyToAddress(privateKeyECDSA.PublicKey)
-	nonce, err := ctrl.Client.PendingNonceAt(context.Background(), fromAddress)
+// CheckOwner 检查执行者私钥对应的地址是否为预言机所有者，不是时 setPublicKey 会被合约拒绝
+func (ctrl *PublicKeyOracleController) CheckOwner() {
+	signer, err := currentSigner()
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("error getting nonce: %v", err)
+		log.Printf("WARNING: cannot check PublicKeyOracle owner: %v", err)
+		return
 	}
+	executor := signer.Address()
 
-	// 获取建议的 gas price
-	gasPrice, err := ctrl.Client.SuggestGasPrice(context.Background())
+	owner, err := ctrl.Owner()
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("error getting gas price: %v", err)
+		log.Printf("WARNING: cannot check PublicKeyOracle owner: %v", err)
+		return
 	}
+	if owner != executor {
+		log.Printf("WARNING: executor %s is not the PublicKeyOracle owner (%s), setPublicKey will revert", executor.Hex(), owner.Hex())
+	}
+}
 
-	// 计算发送交易需要的 gas limit
-	gasLimit := uint64(200000) // 根据实际情况调整
+// RequestOwnershipTransfer 发起所有权转移，返回确认令牌，需要在有效期内调用 ConfirmOwnershipTransfer 才会真正发送交易
+func (ctrl *PublicKeyOracleController) RequestOwnershipTransfer(newOwner common.Address) (string, time.Time, error) {
+	if newOwner == (common.Address{}) {
+		return "", time.Time{}, fmt.Errorf("new owner cannot be the zero address")
+	}
 
-	// 构造调用数据
-	callData, err := contractAbi.Pack("getRSAKey", domain, selector)
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("error packing data: %v", err)
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", time.Time{}, fmt.Errorf("error generating confirmation token: %v", err)
 	}
+	token := hex.EncodeToString(tokenBytes)
+	expiresAt := time.Now().Add(ownershipTransferTTL)
 
-	// 创建交易对象
-	value := big.NewInt(0)
-	toAddress := common.HexToAddress(publicKeyOracleAddress)
-	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, callData)
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
 
-	// 获取网络 ID
-	networkId, err := ctrl.Client.NetworkID(context.Background())
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("error getting network ID: %v", err)
+	// 顺便清理已过期的请求
+	for key, pending := range ctrl.pendingTransfers {
+		if time.Now().After(pending.ExpiresAt) {
+			delete(ctrl.pendingTransfers, key)
+		}
 	}
+	ctrl.pendingTransfers[token] = pendingOwnershipTransfer{NewOwner: newOwner, ExpiresAt: expiresAt}
+	return token, expiresAt, nil
+}
 
-	// 签署交易
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(networkId), privateKeyECDSA)
-	if err != nil {
-		return "", nil, nil, fmt.Errorf("error signing transaction: %v", err)
+// ConfirmOwnershipTransfer 使用确认令牌调用合约的 transferOwnership 方法
+func (ctrl *PublicKeyOracleController) ConfirmOwnershipTransfer(token string) (string, common.Address, error) {
+	ctrl.mu.Lock()
+	pending, ok := ctrl.pendingTransfers[token]
+	delete(ctrl.pendingTransfers, token)
+	ctrl.mu.Unlock()
+
+	if !ok || time.Now().After(pending.ExpiresAt) {
+		return "", common.Address{}, ErrInvalidConfirmationToken
 	}
 
-	// 发送交易到区块链
-	err = ctrl.Client.SendTransaction(context.Background(), signedTx)
+	txHash, err := ctrl.sendOracleTransaction("transferOwnership", pending.NewOwner)
 	if err != nil {
-		return "", nil, nil, fmt.Errorf("error sending transaction: %v", err)
+		return "", common.Address{}, err
 	}
 
-	// 解析交易事件数据  这里是如果没有返回数据就会报错，所以先注释
-	// 等待交易完成
-	// receipt, err := bind.WaitMined(context.Background(), ctrl.Client, signedTx)
-	// if err != nil {
-	// 	return "", nil, nil, fmt.Errorf("error waiting for transaction to be mined: %v", err)
-	// }
+	fmt.Printf("TransferOwnership transaction sent with hash: %s\n", txHash)
+	return txHash, pending.NewOwner, nil
+}
+
+// loadPublicKeyOracleABI 读取并解析 PublicKeyOracle 合约 ABI
+func loadPublicKeyOracleABI() (abi.ABI, error) {
+	return loadABI(os.Getenv("PublicKeyOracle_ABI"))
+}
+
+// sendOracleTransaction 使用执行者私钥签名并发送一笔调用预言机合约的交易
+func (ctrl *PublicKeyOracleController) sendOracleTransaction(method string, args ...interface{}) (string, error) {
+	// 使用启动时加载的执行者签名器
+	signer, err := currentSigner()
+	if err != nil {
+		return "", err
+	}
+	fromAddress := signer.Address()
 
-	// 解析交易事件数据  这里是如果没有返回数据就会报错，所以先注释
-	// var result map[string]interface{}
-	//如果没有接收到数据就直接报错（后加）
-	// if len(receipt.Logs) == 0 {
-	// 	return "", nil, nil, fmt.Errorf("no logs found in receipt")
-	// }
+	data, err := ctrl.oracleAbi.Pack(method, args...)
+	if err != nil {
+		return "", fmt.Errorf("error packing data: %v", err)
+	}
 
-	// err = contractAbi.UnpackIntoMap(result, "getRSAKey", receipt.Logs[0].Data)
-	// if err != nil {
-	// 	return "", nil, nil, fmt.Errorf("error unpacking result: %v", err)
-	// }
+	gasPrice, err := ctrl.Client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("error getting gas price: %v", err)
+	}
 
-	// // 提取结果中的 Modulus 和 Exponent
-	// modulus, ok := result["Modulus"].([]byte)
-	// if !ok {
-	// 	return "", nil, nil, fmt.Errorf("error casting modulus to []byte")
-	// }
+	chainID, err := ctrl.Client.NetworkID(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("error getting network ID: %v", err)
+	}
 
-	// exponent, ok := result["Exponent"].([]byte)
-	// if !ok {
-	// 	return "", nil, nil, fmt.Errorf("error casting exponent to []byte")
-	// }
+	nonce, err := ctrl.Nonces.Next(fromAddress)
+	if err != nil {
+		return "", err
+	}
 
-	// 返回交易哈希和结果
-	txHash := signedTx.Hash().Hex()
+	gasLimit := uint64(200000) // 根据实际情况调整
+	toAddress := common.HexToAddress(publicKeyOracleAddress)
+	tx := types.NewTransaction(nonce, toAddress, big.NewInt(0), gasLimit, gasPrice, data)
 
-	// return txHash, modulus, exponent, nil
-	return txHash, nil, nil, nil //无数据暂时返回空
+	signedTx, err := signer.SignTx(tx, chainID)
+	if err != nil {
+		ctrl.Nonces.Release(fromAddress, nonce)
+		return "", fmt.Errorf("error signing transaction: %v", err)
+	}
 
+	if err := ctrl.Client.SendTransaction(context.Background(), signedTx); err != nil {
+		ctrl.Nonces.Release(fromAddress, nonce)
+		return "", fmt.Errorf("error sending transaction: %v", err)
+	}
+	ctrl.Nonces.Sent(fromAddress, nonce)
+	return signedTx.Hash().Hex(), nil
 }
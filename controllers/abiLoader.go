@@ -0,0 +1,24 @@
+// abiLoader.go
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// loadABI 读取并解析指定路径的合约 ABI
+func loadABI(abiPath string) (abi.ABI, error) {
+	var contractAbi abi.ABI
+	abiData, err := os.ReadFile(abiPath)
+	if err != nil {
+		return contractAbi, fmt.Errorf("error reading ABI file: %v", err)
+	}
+
+	if err := contractAbi.UnmarshalJSON(abiData); err != nil {
+		return contractAbi, fmt.Errorf("error parsing ABI: %v", err)
+	}
+	return contractAbi, nil
+}
@@ -0,0 +1,253 @@
+// balanceMonitor.go
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	defaultBalancePollInterval = 3 * time.Second
+
+	balanceLevelOK       = "ok"
+	balanceLevelWarning  = "warning"
+	balanceLevelCritical = "critical"
+	balanceLevelRecover  = "recovered"
+)
+
+// BalanceMonitor 每个新区块检查执行者余额，低于临界值时暂停打包并通过 webhook 告警
+type BalanceMonitor struct {
+	client        *ethclient.Client
+	pool          *ExecutorPool
+	warnLevel     *big.Int // 低于该值时发出 warning 告警
+	criticalLevel *big.Int // 低于该值时暂停该执行者
+	webhookURL    string
+	interval      time.Duration
+	httpClient    *http.Client
+
+	mu        sync.Mutex
+	lastBlock uint64
+	balances  map[common.Address]*models.ExecutorBalance
+}
+
+// NewBalanceMonitor 根据 BALANCE_WARN_LEVEL、BALANCE_CRITICAL_LEVEL（wei）和 BALANCE_ALERT_WEBHOOK 创建余额监控
+// 未配置阈值时只记录余额，不告警也不暂停
+func NewBalanceMonitor(client *ethclient.Client, pool *ExecutorPool) (*BalanceMonitor, error) {
+	warnLevel, err := optionalWeiFromEnv("BALANCE_WARN_LEVEL")
+	if err != nil {
+		return nil, err
+	}
+	criticalLevel, err := optionalWeiFromEnv("BALANCE_CRITICAL_LEVEL")
+	if err != nil {
+		return nil, err
+	}
+	if warnLevel.Cmp(criticalLevel) < 0 {
+		warnLevel = criticalLevel
+	}
+
+	interval := defaultBalancePollInterval
+	if seconds, err := strconv.Atoi(os.Getenv("BALANCE_POLL_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	return &BalanceMonitor{
+		client:        client,
+		pool:          pool,
+		warnLevel:     warnLevel,
+		criticalLevel: criticalLevel,
+		webhookURL:    os.Getenv("BALANCE_ALERT_WEBHOOK"),
+		interval:      interval,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		balances:      make(map[common.Address]*models.ExecutorBalance),
+	}, nil
+}
+
+// Run 轮询最新区块号，每出一个新区块检查一次余额，阻塞运行
+func (m *BalanceMonitor) Run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		blockNumber, err := m.client.BlockNumber(context.Background())
+		if err != nil {
+			log.Printf("Failed to get block number for balance monitor: %v", err)
+		} else if blockNumber != m.lastCheckedBlock() {
+			m.checkOnce(blockNumber)
+		}
+		<-ticker.C
+	}
+}
+
+// lastCheckedBlock 返回上一次检查余额时的区块号
+func (m *BalanceMonitor) lastCheckedBlock() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastBlock
+}
+
+// checkOnce 在指定区块检查所有执行者余额，级别变化时更新暂停状态并告警
+func (m *BalanceMonitor) checkOnce(blockNumber uint64) {
+	for _, address := range m.pool.Addresses() {
+		balance, err := m.client.BalanceAt(context.Background(), address, new(big.Int).SetUint64(blockNumber))
+		if err != nil {
+			log.Printf("Failed to get balance of executor %s: %v", address.Hex(), err)
+			continue
+		}
+
+		level := m.levelOf(balance)
+		paused := level == balanceLevelCritical
+		m.pool.SetPaused(address, paused)
+
+		m.mu.Lock()
+		previous := balanceLevelOK
+		if status, ok := m.balances[address]; ok {
+			previous = status.Level
+		}
+		m.balances[address] = &models.ExecutorBalance{
+			Address:     address.Hex(),
+			Balance:     balance,
+			Level:       level,
+			Paused:      paused,
+			BlockNumber: blockNumber,
+		}
+		m.mu.Unlock()
+
+		if level == previous {
+			continue
+		}
+		alert := models.BalanceAlert{
+			Time:        time.Now().Unix(),
+			Address:     address.Hex(),
+			Level:       level,
+			Balance:     balance,
+			Threshold:   m.warnLevel,
+			BlockNumber: blockNumber,
+		}
+		switch level {
+		case balanceLevelCritical:
+			alert.Threshold = m.criticalLevel
+			log.Printf("Executor %s balance %v below critical level %v, bundling paused", address.Hex(), balance, m.criticalLevel)
+		case balanceLevelWarning:
+			log.Printf("Executor %s balance %v below warning level %v", address.Hex(), balance, m.warnLevel)
+		default:
+			alert.Level = balanceLevelRecover
+			log.Printf("Executor %s balance %v recovered", address.Hex(), balance)
+		}
+		go m.sendAlert(alert)
+	}
+
+	m.mu.Lock()
+	m.lastBlock = blockNumber
+	m.mu.Unlock()
+}
+
+// levelOf 根据阈值判断余额级别
+func (m *BalanceMonitor) levelOf(balance *big.Int) string {
+	switch {
+	case balance.Cmp(m.criticalLevel) < 0:
+		return balanceLevelCritical
+	case balance.Cmp(m.warnLevel) < 0:
+		return balanceLevelWarning
+	default:
+		return balanceLevelOK
+	}
+}
+
+// sendAlert 将告警以 JSON POST 到 webhook，未配置时忽略
+func (m *BalanceMonitor) sendAlert(alert models.BalanceAlert) {
+	if m.webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Failed to encode balance alert: %v", err)
+		return
+	}
+	resp, err := m.httpClient.Post(m.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send balance alert: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		log.Printf("Balance alert webhook returned status %d", resp.StatusCode)
+	}
+}
+
+// Balances 返回最近一次检查的执行者余额
+func (m *BalanceMonitor) Balances() []models.ExecutorBalance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []models.ExecutorBalance
+	for _, address := range m.pool.Addresses() {
+		if status, ok := m.balances[address]; ok {
+			result = append(result, *status)
+		}
+	}
+	return result
+}
+
+// Paused 判断打包是否因余额不足而暂停
+func (m *BalanceMonitor) Paused() bool {
+	return m.pool.Paused()
+}
+
+// Metrics 以 Prometheus 文本格式输出执行者余额指标
+func (m *BalanceMonitor) Metrics() string {
+	var sb strings.Builder
+	sb.WriteString("# HELP bundler_executor_balance_wei Executor account balance in wei.\n")
+	sb.WriteString("# TYPE bundler_executor_balance_wei gauge\n")
+	balances := m.Balances()
+	for _, status := range balances {
+		fmt.Fprintf(&sb, "bundler_executor_balance_wei{address=%q} %s\n", status.Address, status.Balance.String())
+	}
+	sb.WriteString("# HELP bundler_executor_paused Whether the executor is paused for low balance.\n")
+	sb.WriteString("# TYPE bundler_executor_paused gauge\n")
+	for _, status := range balances {
+		fmt.Fprintf(&sb, "bundler_executor_paused{address=%q} %d\n", status.Address, boolToInt(status.Paused))
+	}
+	sb.WriteString("# HELP bundler_bundling_paused Whether bundling is paused because every executor is below the critical level.\n")
+	sb.WriteString("# TYPE bundler_bundling_paused gauge\n")
+	fmt.Fprintf(&sb, "bundler_bundling_paused %d\n", boolToInt(m.Paused()))
+	sb.WriteString("# HELP bundler_balance_monitor_block Last block checked by the balance monitor.\n")
+	sb.WriteString("# TYPE bundler_balance_monitor_block gauge\n")
+	fmt.Fprintf(&sb, "bundler_balance_monitor_block %d\n", m.lastCheckedBlock())
+	return sb.String()
+}
+
+// optionalWeiFromEnv 读取 wei 数值，未设置时返回 0
+func optionalWeiFromEnv(key string) (*big.Int, error) {
+	if os.Getenv(key) == "" {
+		return big.NewInt(0), nil
+	}
+	return weiFromEnv(key)
+}
+
+// boolToInt 将布尔值转换为指标使用的 0/1
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
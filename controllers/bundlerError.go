@@ -0,0 +1,158 @@
+// bundlerError.go
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gin-gonic/gin"
+)
+
+// ERC-4337 定义的 JSON-RPC 错误码，以及 JSON-RPC 2.0 的通用错误码
+const (
+	ErrCodeRejected              = -32500 // 被 EntryPoint 或账户的验证拒绝
+	ErrCodePaymaster             = -32501 // 被 paymaster 拒绝
+	ErrCodeOpcode                = -32502 // 使用了禁止的操作码或存储访问
+	ErrCodeTimeRange             = -32503 // validUntil / validAfter 不在有效范围内
+	ErrCodeThrottled             = -32504 // 实体被限流或封禁，bundler 暂时无法接收时也使用该错误码
+	ErrCodeStake                 = -32505 // 实体质押或解锁延迟不足
+	ErrCodeUnsupportedAggregator = -32506 // 不支持的签名聚合器
+	ErrCodeSignature             = -32507 // 签名无效
+
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+)
+
+// BundlerError 带有 ERC-4337 错误码的错误，REST 和 JSON-RPC 接口共用
+type BundlerError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+
+	httpStatus int // 非零时覆盖按错误码推导的 HTTP 状态码
+}
+
+// Error 实现 error 接口
+func (e *BundlerError) Error() string {
+	return e.Message
+}
+
+// HTTPStatus 返回 REST 接口使用的 HTTP 状态码
+func (e *BundlerError) HTTPStatus() int {
+	if e.httpStatus != 0 {
+		return e.httpStatus
+	}
+	switch e.Code {
+	case ErrCodeThrottled:
+		return http.StatusTooManyRequests
+	case ErrCodeMethodNotFound:
+		return http.StatusNotFound
+	case ErrCodeInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// NewBundlerError 创建指定错误码的错误
+func NewBundlerError(code int, format string, args ...interface{}) *BundlerError {
+	return &BundlerError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// invalidParams 包装参数错误
+func invalidParams(err error) *BundlerError {
+	return &BundlerError{Code: ErrCodeInvalidParams, Message: err.Error()}
+}
+
+// AsBundlerError 将任意错误转换为 BundlerError，未分类的错误视为内部错误
+func AsBundlerError(err error) *BundlerError {
+	var bundlerErr *BundlerError
+	if errors.As(err, &bundlerErr) {
+		return bundlerErr
+	}
+	if errors.Is(err, ErrNoIdleExecutor) || errors.Is(err, ErrBundlingPaused) {
+		return &BundlerError{Code: ErrCodeThrottled, Message: err.Error(), httpStatus: http.StatusServiceUnavailable}
+	}
+	return &BundlerError{Code: ErrCodeInternal, Message: err.Error()}
+}
+
+// RespondError 以 {"error", "code", "data"} 格式返回 REST 错误
+func RespondError(c *gin.Context, err error) {
+	bundlerErr := AsBundlerError(err)
+	body := gin.H{"error": bundlerErr.Message, "code": bundlerErr.Code}
+	if bundlerErr.Data != nil {
+		body["data"] = bundlerErr.Data
+	}
+	c.JSON(bundlerErr.HTTPStatus(), body)
+}
+
+// RespondInvalidParams 以 -32602 返回请求参数错误
+func RespondInvalidParams(c *gin.Context, format string, args ...interface{}) {
+	RespondError(c, NewBundlerError(ErrCodeInvalidParams, format, args...))
+}
+
+// failedOpError 根据 EntryPoint FailedOp 的 AAxx 原因选择错误码，data 为解码后的 revert 原因
+func failedOpError(opIndex uint64, reason string, data *models.RevertReason) *BundlerError {
+	code := ErrCodeRejected
+	switch {
+	case strings.HasPrefix(reason, "AA22"), strings.HasPrefix(reason, "AA32"):
+		// 账户或 paymaster 的 validUntil / validAfter 已过期或尚未生效
+		code = ErrCodeTimeRange
+	case strings.HasPrefix(reason, "AA24"), strings.HasPrefix(reason, "AA34"):
+		code = ErrCodeSignature
+	case strings.HasPrefix(reason, "AA96"):
+		code = ErrCodeUnsupportedAggregator
+	case strings.HasPrefix(reason, "AA3"):
+		code = ErrCodePaymaster
+	}
+	message := fmt.Sprintf("UserOp %d rejected: %s", opIndex, reason)
+	if data != nil && data.Inner != nil {
+		message += ": " + data.Inner.Message
+	}
+	return &BundlerError{Code: code, Message: message, Data: data}
+}
+
+// revertData 从节点返回的错误中提取 revert 数据，没有时返回 nil
+func revertData(err error) []byte {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return nil
+	}
+	hexData, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil
+	}
+	data, decodeErr := hexutil.Decode(hexData)
+	if decodeErr != nil {
+		return nil
+	}
+	return data
+}
+
+// handleOpsError 将 handleOps 的 revert 解码后转换为 BundlerError，没有 revert 数据时视为节点错误
+func handleOpsError(contractAbi abi.ABI, err error) error {
+	data := revertData(err)
+	if data == nil {
+		return fmt.Errorf("error simulating handleOps: %v", err)
+	}
+
+	reason := DecodeRevert(contractAbi, data)
+	log.Printf("handleOps simulation reverted: %s (%s)", reason.Message, reason.Raw)
+	if reason.Name == "FailedOp" || reason.Name == "FailedOpWithRevert" {
+		opIndex := reason.Args[0].Value.(*big.Int).Uint64()
+		return failedOpError(opIndex, reason.Args[1].Value.(string), reason)
+	}
+	return &BundlerError{Code: ErrCodeRejected, Message: fmt.Sprintf("handleOps reverted: %s", reason.Message), Data: reason}
+}
@@ -4,19 +4,27 @@ package controllers
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"encoding/json"
 	"fmt"
 	"math/big"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+const (
+	defaultDepositWaitTimeout = 2 * time.Minute
+	replacementGasPriceBump   = 25 // 替换交易的 gas price 相对原交易提高的百分比，节点至少要求 10%
+)
+
 // DepositController 控制器结构
 type DepositController struct {
 	Client *ethclient.Client
@@ -36,81 +44,420 @@ func NewDepositController() (*DepositController, error) {
 }
 
 // DepositToAddress 调用 depositTo 方法，向指定地址存款
-func (ctrl *DepositController) DepositToAddress(address common.Address, amount *big.Int) (string, error) {
-	privateKey := os.Getenv("PRIVATE_KEY")
-	abiPath := os.Getenv("ABI_PATH")
+// wait 为 true 时等待交易上链并解析 Deposited 事件，返回最新的存款总额
+func (ctrl *DepositController) DepositToAddress(address common.Address, amount *big.Int, wait bool) (string, *models.DepositStatus, error) {
+	txHash, err := ctrl.sendEntryPointTransaction(amount, "depositTo", address)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 输出交易哈希
+	fmt.Printf("Deposit transaction sent with hash: %s\n", txHash)
+	if !wait {
+		return txHash, nil, nil
+	}
+
+	receipt, err := ctrl.waitForReceipt(common.HexToHash(txHash))
+	if err != nil {
+		return txHash, nil, err
+	}
 
-	// 将私钥字符串转换为 ECDSA 私钥
-	privateKeyECDSA, err := crypto.HexToECDSA(privateKey)
+	status, err := parseDepositReceipt(receipt)
 	if err != nil {
-		return "", fmt.Errorf("error converting private key: %v", err)
+		return txHash, nil, err
+	}
+	return txHash, status, nil
+}
+
+// GetDepositStatus 根据交易哈希查询 depositTo 交易的确认状态
+// 没有回执时只有节点仍然知道该交易才返回 pending，否则交易不存在或已被丢弃，返回 404
+func (ctrl *DepositController) GetDepositStatus(txHash common.Hash) (*models.DepositStatus, error) {
+	receipt, err := ctrl.Client.TransactionReceipt(context.Background(), txHash)
+	if err == nil {
+		return parseDepositReceipt(receipt)
+	}
+	if err != ethereum.NotFound {
+		return nil, fmt.Errorf("error getting transaction receipt: %v", err)
+	}
+
+	if _, _, err := ctrl.Client.TransactionByHash(context.Background(), txHash); err != nil {
+		if err == ethereum.NotFound {
+			return nil, &BundlerError{Code: ErrCodeInvalidParams, Message: fmt.Sprintf("transaction %s not found", txHash.Hex()), httpStatus: http.StatusNotFound}
+		}
+		return nil, fmt.Errorf("error getting transaction: %v", err)
+	}
+	return &models.DepositStatus{TransactionHash: txHash.Hex(), Status: "pending"}, nil
+}
+
+// waitForReceipt 轮询交易回执，直到交易上链或超时
+func (ctrl *DepositController) waitForReceipt(txHash common.Hash) (*types.Receipt, error) {
+	timeout := defaultDepositWaitTimeout
+	if seconds, err := strconv.Atoi(os.Getenv("DEPOSIT_WAIT_TIMEOUT")); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		receipt, err := ctrl.Client.TransactionReceipt(ctx, txHash)
+		if err == nil {
+			return receipt, nil
+		}
+		if err != ethereum.NotFound {
+			return nil, fmt.Errorf("error getting transaction receipt: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for transaction %s to be mined", txHash.Hex())
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseDepositReceipt 从交易回执中解析 EntryPoint 发出的 Deposited(account, totalDeposit) 事件
+func parseDepositReceipt(receipt *types.Receipt) (*models.DepositStatus, error) {
+	status := &models.DepositStatus{
+		TransactionHash: receipt.TxHash.Hex(),
+		BlockNumber:     receipt.BlockNumber,
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		status.Status = "failed"
+		return status, nil
+	}
+	status.Status = "success"
+
+	contractAbi, err := loadEntryPointABI()
+	if err != nil {
+		return nil, err
+	}
+	depositedEvent := contractAbi.Events["Deposited"]
+
+	for _, vLog := range receipt.Logs {
+		if vLog.Address != common.HexToAddress(entryPointAddress) || len(vLog.Topics) < 2 || vLog.Topics[0] != depositedEvent.ID {
+			continue
+		}
+
+		var event struct {
+			TotalDeposit *big.Int
+		}
+		if err := contractAbi.UnpackIntoInterface(&event, "Deposited", vLog.Data); err != nil {
+			return nil, fmt.Errorf("error unpacking Deposited event: %v", err)
+		}
+		status.Account = common.BytesToAddress(vLog.Topics[1].Bytes()).Hex()
+		status.TotalDeposit = event.TotalDeposit
+		break
+	}
+	return status, nil
+}
+
+// AddStake 调用 addStake 方法，为执行者账户增加质押并设置解锁延迟
+// wait 为 true 时等待交易上链并解析 StakeLocked 事件
+func (ctrl *DepositController) AddStake(unstakeDelaySec uint32, amount *big.Int, wait bool) (string, *models.StakeStatus, error) {
+	txHash, err := ctrl.sendEntryPointTransaction(amount, "addStake", unstakeDelaySec)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fmt.Printf("AddStake transaction sent with hash: %s\n", txHash)
+	return ctrl.waitForStakeEvent(txHash, "StakeLocked", wait)
+}
+
+// UnlockStake 调用 unlockStake 方法，开始解锁执行者账户的质押
+// wait 为 true 时等待交易上链并解析 StakeUnlocked 事件
+func (ctrl *DepositController) UnlockStake(wait bool) (string, *models.StakeStatus, error) {
+	txHash, err := ctrl.sendEntryPointTransaction(big.NewInt(0), "unlockStake")
+	if err != nil {
+		return "", nil, err
+	}
+
+	fmt.Printf("UnlockStake transaction sent with hash: %s\n", txHash)
+	return ctrl.waitForStakeEvent(txHash, "StakeUnlocked", wait)
+}
+
+// WithdrawStake 调用 withdrawStake 方法，将已解锁的质押提取到指定地址
+// wait 为 true 时等待交易上链并解析 StakeWithdrawn 事件
+func (ctrl *DepositController) WithdrawStake(withdrawAddress common.Address, wait bool) (string, *models.StakeStatus, error) {
+	txHash, err := ctrl.sendEntryPointTransaction(big.NewInt(0), "withdrawStake", withdrawAddress)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fmt.Printf("WithdrawStake transaction sent with hash: %s\n", txHash)
+	return ctrl.waitForStakeEvent(txHash, "StakeWithdrawn", wait)
+}
+
+// WithdrawTo 调用 withdrawTo 方法，将执行者账户的存款提取到指定地址
+// wait 为 true 时等待交易上链并解析 Withdrawn 事件
+func (ctrl *DepositController) WithdrawTo(withdrawAddress common.Address, amount *big.Int, wait bool) (string, *models.StakeStatus, error) {
+	txHash, err := ctrl.sendEntryPointTransaction(big.NewInt(0), "withdrawTo", withdrawAddress, amount)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fmt.Printf("WithdrawTo transaction sent with hash: %s\n", txHash)
+	return ctrl.waitForStakeEvent(txHash, "Withdrawn", wait)
+}
+
+// waitForStakeEvent 等待质押或提取交易上链，解析指定事件，并读取交易所在区块之后的质押信息
+func (ctrl *DepositController) waitForStakeEvent(txHash string, eventName string, wait bool) (string, *models.StakeStatus, error) {
+	if !wait {
+		return txHash, nil, nil
+	}
+
+	receipt, err := ctrl.waitForReceipt(common.HexToHash(txHash))
+	if err != nil {
+		return txHash, nil, err
+	}
+
+	status, err := parseStakeReceipt(receipt, eventName)
+	if err != nil {
+		return txHash, nil, err
+	}
+	if status.Status != "success" || status.Account == "" {
+		return txHash, status, nil
+	}
+
+	// 事件只带部分字段，质押总额、解锁延迟和可提取时间从交易所在区块的 getDepositInfo 读取
+	info, err := ctrl.getDepositInfoAt(common.HexToAddress(status.Account), receipt.BlockNumber)
+	if err != nil {
+		return txHash, status, err
+	}
+	status.Stake = info.Stake
+	status.UnstakeDelaySec = info.UnstakeDelaySec
+	status.WithdrawTime = info.WithdrawTime
+	status.Deposit = info.Deposit
+	return txHash, status, nil
+}
+
+// parseStakeReceipt 从交易回执中解析 EntryPoint 发出的 StakeLocked / StakeUnlocked / StakeWithdrawn / Withdrawn 事件
+func parseStakeReceipt(receipt *types.Receipt, eventName string) (*models.StakeStatus, error) {
+	status := &models.StakeStatus{
+		TransactionHash: receipt.TxHash.Hex(),
+		BlockNumber:     receipt.BlockNumber,
+		Event:           eventName,
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		status.Status = "failed"
+		return status, nil
 	}
+	status.Status = "success"
 
-	publicKey := privateKeyECDSA.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
+	contractAbi, err := loadEntryPointABI()
+	if err != nil {
+		return nil, err
+	}
+	event, ok := contractAbi.Events[eventName]
 	if !ok {
-		return "", fmt.Errorf("error asserting type of public key")
+		return nil, fmt.Errorf("EntryPoint ABI has no %s event", eventName)
 	}
 
-	// 从公钥推导出以太坊地址
-	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
+	for _, vLog := range receipt.Logs {
+		if vLog.Address != common.HexToAddress(entryPointAddress) || len(vLog.Topics) < 2 || vLog.Topics[0] != event.ID {
+			continue
+		}
 
-	// 读取并解析 ABI 文件
-	abiData, err := os.ReadFile(abiPath)
+		// 四个事件的非 indexed 字段是以下字段的子集，质押总额、解锁延迟和可提取时间之后统一从 getDepositInfo 读取
+		var fields struct {
+			TotalStaked     *big.Int
+			UnstakeDelaySec *big.Int
+			WithdrawTime    *big.Int
+			WithdrawAddress common.Address
+			Amount          *big.Int
+		}
+		if err := contractAbi.UnpackIntoInterface(&fields, eventName, vLog.Data); err != nil {
+			return nil, fmt.Errorf("error unpacking %s event: %v", eventName, err)
+		}
+		status.Account = common.BytesToAddress(vLog.Topics[1].Bytes()).Hex()
+		status.Amount = fields.Amount
+		if fields.WithdrawAddress != (common.Address{}) {
+			status.WithdrawAddress = fields.WithdrawAddress.Hex()
+		}
+		break
+	}
+	return status, nil
+}
+
+// GetDepositInfo 调用 getDepositInfo 方法，获取指定地址的存款与质押信息
+func (ctrl *DepositController) GetDepositInfo(address common.Address) (*models.DepositInfo, error) {
+	return ctrl.getDepositInfoAt(address, nil)
+}
+
+// getDepositInfoAt 读取指定区块的存款与质押信息，blockNumber 为 nil 时读取最新区块
+func (ctrl *DepositController) getDepositInfoAt(address common.Address, blockNumber *big.Int) (*models.DepositInfo, error) {
+	contractAbi, err := loadEntryPointABI()
 	if err != nil {
-		return "", fmt.Errorf("error reading ABI file: %v", err)
+		return nil, err
 	}
 
-	var contractAbi abi.ABI
-	if err := json.Unmarshal(abiData, &contractAbi); err != nil {
-		return "", fmt.Errorf("error parsing ABI: %v", err)
+	result, err := ctrl.callEntryPointAt(contractAbi, blockNumber, "getDepositInfo", address)
+	if err != nil {
+		return nil, err
 	}
 
-	// 使用 ABI 打包数据以调用 depositTo 方法
-	data, err := contractAbi.Pack("depositTo", address)
+	// getDepositInfo 只返回一个 tuple，需要先解包再转换为结构体
+	out, err := contractAbi.Unpack("getDepositInfo", result)
 	if err != nil {
-		return "", fmt.Errorf("error packing data: %v", err)
+		return nil, fmt.Errorf("error unpacking result: %v", err)
 	}
+	info := abi.ConvertType(out[0], new(models.DepositInfo)).(*models.DepositInfo)
+	return info, nil
+}
 
-	// 获取账户的当前 nonce
-	nonce, err := ctrl.Client.PendingNonceAt(context.Background(), fromAddress)
+// BalanceOf 调用 balanceOf 方法，获取指定地址在 EntryPoint 中的存款余额
+func (ctrl *DepositController) BalanceOf(address common.Address) (*big.Int, error) {
+	contractAbi, err := loadEntryPointABI()
 	if err != nil {
-		return "", fmt.Errorf("error getting nonce: %v", err)
+		return nil, err
+	}
+
+	result, err := ctrl.callEntryPoint(contractAbi, "balanceOf", address)
+	if err != nil {
+		return nil, err
+	}
+
+	var balance *big.Int
+	if err := contractAbi.UnpackIntoInterface(&balance, "balanceOf", result); err != nil {
+		return nil, fmt.Errorf("error unpacking result: %v", err)
+	}
+	return balance, nil
+}
+
+// loadEntryPointABI 读取并解析 EntryPoint 合约 ABI
+func loadEntryPointABI() (abi.ABI, error) {
+	return loadABI(os.Getenv("ABI_PATH"))
+}
+
+// callEntryPoint 以 eth_call 方式调用 EntryPoint 合约的只读方法
+func (ctrl *DepositController) callEntryPoint(contractAbi abi.ABI, method string, args ...interface{}) ([]byte, error) {
+	return ctrl.callEntryPointAt(contractAbi, nil, method, args...)
+}
+
+// callEntryPointAt 在指定区块调用 EntryPoint 合约的只读方法，blockNumber 为 nil 时使用最新区块
+func (ctrl *DepositController) callEntryPointAt(contractAbi abi.ABI, blockNumber *big.Int, method string, args ...interface{}) ([]byte, error) {
+	data, err := contractAbi.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error packing data: %v", err)
+	}
+
+	toAddress := common.HexToAddress(entryPointAddress)
+	result, err := ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: data}, blockNumber)
+	if err != nil {
+		return nil, withRevertReason(contractAbi, "error calling "+method, err)
+	}
+	return result, nil
+}
+
+// replacementTx 替换未确认交易时使用的 nonce 和原交易的 gas price
+type replacementTx struct {
+	nonce    uint64
+	gasPrice *big.Int
+}
+
+// sendEntryPointTransaction 使用执行者私钥签名并发送一笔调用 EntryPoint 合约的交易
+func (ctrl *DepositController) sendEntryPointTransaction(value *big.Int, method string, args ...interface{}) (string, error) {
+	signedTx, err := ctrl.sendEntryPointTx(value, nil, method, args...)
+	if err != nil {
+		return "", err
+	}
+	return signedTx.Hash().Hex(), nil
+}
+
+// SendDepositTo 发送 depositTo 交易并返回已签名的交易，不等待上链
+func (ctrl *DepositController) SendDepositTo(address common.Address, amount *big.Int) (*types.Transaction, error) {
+	return ctrl.sendEntryPointTx(amount, nil, "depositTo", address)
+}
+
+// ReplaceDepositTo 以相同的 nonce 和更高的 gas price 重新发送 depositTo 交易，替换长时间未确认的交易
+func (ctrl *DepositController) ReplaceDepositTo(address common.Address, amount *big.Int, nonce uint64, gasPrice *big.Int) (*types.Transaction, error) {
+	return ctrl.sendEntryPointTx(amount, &replacementTx{nonce: nonce, gasPrice: gasPrice}, "depositTo", address)
+}
+
+// sendEntryPointTx 签名并发送调用 EntryPoint 合约的交易
+// replacement 不为空时沿用其 nonce，gas price 至少比原交易高 replacementGasPriceBump 百分比，否则节点不接受替换
+func (ctrl *DepositController) sendEntryPointTx(value *big.Int, replacement *replacementTx, method string, args ...interface{}) (*types.Transaction, error) {
+
+	// 使用启动时加载的执行者签名器
+	signer, err := currentSigner()
+	if err != nil {
+		return nil, err
+	}
+	fromAddress := signer.Address()
+
+	// 读取并解析 ABI 文件
+	contractAbi, err := loadEntryPointABI()
+	if err != nil {
+		return nil, err
+	}
+
+	// 使用 ABI 打包调用数据
+	data, err := contractAbi.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error packing data: %v", err)
 	}
 
 	// 获取建议的 gas price
 	gasPrice, err := ctrl.Client.SuggestGasPrice(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("error getting gas price: %v", err)
+		return nil, fmt.Errorf("error getting gas price: %v", err)
+	}
+
+	// 获取区块链的 chain ID
+	chainID, err := ctrl.Client.NetworkID(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error getting network ID: %v", err)
+	}
+
+	// 执行者私钥同时被打包、预言机等路径使用，nonce 统一由进程内共享的 NonceManager 分配
+	// 替换交易沿用原交易的 nonce，原 nonce 已经广播，不需要再经过 NonceManager
+	nonces := SharedNonceManager(ctrl.Client)
+	var nonce uint64
+	if replacement != nil {
+		nonce = replacement.nonce
+		bumped := new(big.Int).Mul(replacement.gasPrice, big.NewInt(100+replacementGasPriceBump))
+		bumped.Div(bumped, big.NewInt(100))
+		if gasPrice.Cmp(bumped) < 0 {
+			gasPrice = bumped
+		}
+	} else {
+		nonce, err = nonces.Next(fromAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	release := func() {
+		if replacement == nil {
+			nonces.Release(fromAddress, nonce)
+		}
 	}
 
 	// 计算发送交易需要的 gas limit
 	gasLimit := uint64(200000) // 根据实际情况调整
 
 	// 创建交易对象
-	value := amount
 	toAddress := common.HexToAddress(entryPointAddress)
 	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, data)
 
-	// 获取区块链的 chain ID
-	chainID, err := ctrl.Client.NetworkID(context.Background())
-	if err != nil {
-		return "", fmt.Errorf("error getting network ID: %v", err)
-	}
-
 	// 签署交易
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKeyECDSA)
+	signedTx, err := signer.SignTx(tx, chainID)
 	if err != nil {
-		return "", fmt.Errorf("error signing transaction: %v", err)
+		release()
+		return nil, fmt.Errorf("error signing transaction: %v", err)
 	}
 
 	// 发送交易到区块链
 	err = ctrl.Client.SendTransaction(context.Background(), signedTx)
 	if err != nil {
-		return "", fmt.Errorf("error sending transaction: %v", err)
+		release()
+		return nil, withRevertReason(contractAbi, "error sending transaction", err)
+	}
+	if replacement == nil {
+		nonces.Sent(fromAddress, nonce)
 	}
 
-	// 输出交易哈希
-	fmt.Printf("Deposit transaction sent with hash: %s\n", signedTx.Hash().Hex())
-	return signedTx.Hash().Hex(), nil
+	return signedTx, nil
 }
@@ -0,0 +1,478 @@
+// depositTopUp.go
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	defaultTopUpInterval     = time.Minute
+	defaultTopUpAuditLogPath = "./data/topup_audit.log"
+	defaultTopUpStatePath    = "./data/topup_pending.json"
+	defaultTopUpPendingTTL   = 10 * time.Minute
+)
+
+// DepositTopUpJob 定期检查受管地址在 EntryPoint 中的存款，低于阈值时自动调用 depositTo 补充
+type DepositTopUpJob struct {
+	deposit      *DepositController
+	addresses    []common.Address
+	lowWater     *big.Int // 低于该值时触发补充
+	target       *big.Int // 补充后的目标存款
+	dailyLimit   *big.Int // 每日（UTC）最多补充的总额
+	interval     time.Duration
+	pendingTTL   time.Duration // 补充交易超过该时间仍未确认时加价替换
+	auditLogPath string
+	statePath    string // 未确认的补充交易保存位置
+
+	mu         sync.Mutex
+	day        string
+	spentToday *big.Int
+	pending    map[common.Address]*models.PendingTopUp // 尚未确认的补充交易
+}
+
+// NewDepositTopUpJob 根据环境变量创建自动补充任务，未配置 TOPUP_ADDRESSES 时返回 nil
+func NewDepositTopUpJob(deposit *DepositController) (*DepositTopUpJob, error) {
+	addressList := os.Getenv("TOPUP_ADDRESSES")
+	if addressList == "" {
+		return nil, nil
+	}
+
+	var addresses []common.Address
+	for _, item := range strings.Split(addressList, ",") {
+		item = strings.TrimSpace(item)
+		if !common.IsHexAddress(item) {
+			return nil, fmt.Errorf("invalid address in TOPUP_ADDRESSES: %q", item)
+		}
+		addresses = append(addresses, common.HexToAddress(item))
+	}
+
+	lowWater, err := weiFromEnv("TOPUP_LOW_WATER")
+	if err != nil {
+		return nil, err
+	}
+	target, err := weiFromEnv("TOPUP_TARGET")
+	if err != nil {
+		return nil, err
+	}
+	dailyLimit, err := weiFromEnv("TOPUP_DAILY_LIMIT")
+	if err != nil {
+		return nil, err
+	}
+	if target.Cmp(lowWater) <= 0 {
+		return nil, fmt.Errorf("TOPUP_TARGET must be greater than TOPUP_LOW_WATER")
+	}
+
+	interval := defaultTopUpInterval
+	if seconds, err := strconv.Atoi(os.Getenv("TOPUP_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	pendingTTL := defaultTopUpPendingTTL
+	if seconds, err := strconv.Atoi(os.Getenv("TOPUP_PENDING_TIMEOUT")); err == nil && seconds > 0 {
+		pendingTTL = time.Duration(seconds) * time.Second
+	}
+
+	auditLogPath := os.Getenv("TOPUP_AUDIT_LOG")
+	if auditLogPath == "" {
+		auditLogPath = defaultTopUpAuditLogPath
+	}
+
+	statePath := os.Getenv("TOPUP_STATE_PATH")
+	if statePath == "" {
+		statePath = defaultTopUpStatePath
+	}
+
+	job := &DepositTopUpJob{
+		deposit:      deposit,
+		addresses:    addresses,
+		lowWater:     lowWater,
+		target:       target,
+		dailyLimit:   dailyLimit,
+		interval:     interval,
+		pendingTTL:   pendingTTL,
+		auditLogPath: auditLogPath,
+		statePath:    statePath,
+		spentToday:   big.NewInt(0),
+		pending:      make(map[common.Address]*models.PendingTopUp),
+	}
+
+	// 从审计日志恢复当天已经花费的额度，从状态文件恢复未确认的交易，避免重启后重复补充
+	if err := job.loadSpentToday(); err != nil {
+		return nil, err
+	}
+	if err := job.loadPending(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run 启动定时检查，阻塞运行
+func (job *DepositTopUpJob) Run() {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		job.checkOnce()
+		<-ticker.C
+	}
+}
+
+// checkOnce 检查所有受管地址并在需要时补充存款
+func (job *DepositTopUpJob) checkOnce() {
+	for _, address := range job.addresses {
+		if job.hasPendingTopUp(address) {
+			continue
+		}
+
+		balance, err := job.deposit.BalanceOf(address)
+		if err != nil {
+			log.Printf("Failed to get deposit of %s: %v", address.Hex(), err)
+			continue
+		}
+		if balance.Cmp(job.lowWater) >= 0 {
+			continue
+		}
+
+		amount := new(big.Int).Sub(job.target, balance)
+		amount, day, ok := job.reserve(amount)
+		if !ok {
+			log.Printf("Daily top-up limit reached, skipping %s (deposit %v)", address.Hex(), balance)
+			continue
+		}
+
+		record := models.TopUpRecord{
+			Time:    time.Now().Unix(),
+			Address: address.Hex(),
+			Balance: balance,
+			Amount:  amount,
+		}
+
+		signedTx, err := job.deposit.SendDepositTo(address, amount)
+		if err != nil {
+			// 交易未发出，退回预留的额度
+			job.release(amount, day)
+			record.Status = models.TopUpFailed
+			record.Error = err.Error()
+			log.Printf("Failed to top up %s: %v", address.Hex(), err)
+		} else {
+			record.Status = models.TopUpSent
+			record.TransactionHash = signedTx.Hash().Hex()
+			job.setPending(address, &models.PendingTopUp{
+				TxHashes: []common.Hash{signedTx.Hash()},
+				Nonce:    signedTx.Nonce(),
+				GasPrice: signedTx.GasPrice(),
+				Amount:   amount,
+				Day:      day,
+				SentAt:   time.Now().Unix(),
+			})
+			log.Printf("Topped up %s with %v wei in transaction %s", address.Hex(), amount, record.TransactionHash)
+		}
+
+		job.audit(record)
+	}
+}
+
+// hasPendingTopUp 判断地址是否还有未确认的补充交易，已上链或已丢失的会被清除
+// 超过 pendingTTL 仍在交易池中的交易以相同 nonce 加价替换，而不是另发一笔，避免两笔都上链超出每日额度
+func (job *DepositTopUpJob) hasPendingTopUp(address common.Address) bool {
+	job.mu.Lock()
+	pending, ok := job.pending[address]
+	job.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	mined, err := job.topUpMined(pending)
+	if err != nil {
+		return true
+	}
+	if mined != nil {
+		log.Printf("Top-up transaction %s for %s mined in block %v", mined.TxHash.Hex(), address.Hex(), mined.BlockNumber)
+		job.setPending(address, nil)
+		return false
+	}
+
+	if reason := job.topUpDropped(pending); reason != "" {
+		// 交易没有上链，资金没有转出，退回预留的额度后允许重新补充
+		latest := pending.TxHashes[len(pending.TxHashes)-1]
+		log.Printf("Top-up transaction %s for %s %s, retrying", latest.Hex(), address.Hex(), reason)
+		job.release(pending.Amount, pending.Day)
+		job.setPending(address, nil)
+		job.audit(models.TopUpRecord{
+			Time:            time.Now().Unix(),
+			Address:         address.Hex(),
+			Status:          models.TopUpDropped,
+			Amount:          pending.Amount,
+			TransactionHash: latest.Hex(),
+			Error:           reason,
+		})
+		return false
+	}
+
+	if time.Since(time.Unix(pending.SentAt, 0)) > job.pendingTTL {
+		job.replaceTopUp(address, pending)
+	}
+	return true
+}
+
+// topUpMined 查询补充交易及其替换交易的回执，任意一笔上链即返回其回执
+func (job *DepositTopUpJob) topUpMined(pending *models.PendingTopUp) (*types.Receipt, error) {
+	for _, txHash := range pending.TxHashes {
+		receipt, err := job.deposit.Client.TransactionReceipt(context.Background(), txHash)
+		if err == nil {
+			return receipt, nil
+		}
+		if err != ethereum.NotFound {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// topUpDropped 判断未确认的补充交易是否已经丢失，返回原因，仍在等待时返回空字符串
+// 只有最新的交易已不在节点中，或执行者的 nonce 已被其他交易使用时才视为丢失
+func (job *DepositTopUpJob) topUpDropped(pending *models.PendingTopUp) string {
+	client := job.deposit.Client
+	latest := pending.TxHashes[len(pending.TxHashes)-1]
+
+	reason := ""
+	_, _, err := client.TransactionByHash(context.Background(), latest)
+	if err == ethereum.NotFound {
+		reason = "was dropped from the transaction pool"
+	} else if err != nil {
+		return ""
+	} else {
+		signer, err := currentSigner()
+		if err != nil {
+			return ""
+		}
+		nonce, err := client.NonceAt(context.Background(), signer.Address(), nil)
+		if err != nil || nonce <= pending.Nonce {
+			return ""
+		}
+		reason = fmt.Sprintf("was replaced (nonce %d already used)", pending.Nonce)
+	}
+
+	// 查询期间交易可能刚好上链，再确认一次回执
+	if mined, err := job.topUpMined(pending); err != nil || mined != nil {
+		return ""
+	}
+	return reason
+}
+
+// replaceTopUp 以相同 nonce 和更高的 gas price 重新发送长时间未确认的补充交易
+func (job *DepositTopUpJob) replaceTopUp(address common.Address, pending *models.PendingTopUp) {
+	previous := pending.TxHashes[len(pending.TxHashes)-1]
+	signedTx, err := job.deposit.ReplaceDepositTo(address, pending.Amount, pending.Nonce, pending.GasPrice)
+	if err != nil {
+		log.Printf("Failed to replace top-up transaction %s for %s: %v", previous.Hex(), address.Hex(), err)
+		return
+	}
+
+	replaced := *pending
+	replaced.TxHashes = append(append([]common.Hash(nil), pending.TxHashes...), signedTx.Hash())
+	replaced.GasPrice = signedTx.GasPrice()
+	replaced.SentAt = time.Now().Unix()
+	job.setPending(address, &replaced)
+
+	log.Printf("Replaced top-up transaction %s for %s with %s (gas price %v)", previous.Hex(), address.Hex(), signedTx.Hash().Hex(), signedTx.GasPrice())
+	job.audit(models.TopUpRecord{
+		Time:            time.Now().Unix(),
+		Address:         address.Hex(),
+		Status:          models.TopUpReplaced,
+		Amount:          pending.Amount,
+		TransactionHash: signedTx.Hash().Hex(),
+		Replaces:        previous.Hex(),
+	})
+}
+
+// setPending 更新地址未确认的补充交易，pending 为 nil 时清除，并写入状态文件
+func (job *DepositTopUpJob) setPending(address common.Address, pending *models.PendingTopUp) {
+	job.mu.Lock()
+	if pending == nil {
+		delete(job.pending, address)
+	} else {
+		job.pending[address] = pending
+	}
+	job.mu.Unlock()
+
+	if err := job.savePending(); err != nil {
+		log.Printf("Failed to save pending top-ups: %v", err)
+	}
+}
+
+// reserve 在每日额度内预留补充金额，额度不足时只预留剩余部分，同时返回额度所属的日期
+func (job *DepositTopUpJob) reserve(amount *big.Int) (*big.Int, string, bool) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	today := time.Now().UTC().Format("2006-01-02")
+	if job.day != today {
+		job.day = today
+		job.spentToday = big.NewInt(0)
+	}
+
+	remaining := new(big.Int).Sub(job.dailyLimit, job.spentToday)
+	if remaining.Sign() <= 0 {
+		return nil, "", false
+	}
+	if amount.Cmp(remaining) > 0 {
+		amount = remaining
+	}
+	job.spentToday.Add(job.spentToday, amount)
+	return amount, today, true
+}
+
+// release 退回预留但未使用的额度，跨日后前一天的额度已经作废，不再退回
+func (job *DepositTopUpJob) release(amount *big.Int, day string) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if day == job.day {
+		job.spentToday.Sub(job.spentToday, amount)
+	}
+}
+
+// audit 写入审计记录，失败时只记录日志
+func (job *DepositTopUpJob) audit(record models.TopUpRecord) {
+	if err := job.appendAudit(record); err != nil {
+		log.Printf("Failed to write top-up audit log: %v", err)
+	}
+}
+
+// appendAudit 以 JSON Lines 格式追加一条审计记录
+func (job *DepositTopUpJob) appendAudit(record models.TopUpRecord) error {
+	if err := os.MkdirAll(filepath.Dir(job.auditLogPath), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(job.auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// loadSpentToday 累加审计日志中当天发出且没有丢失的补充金额
+// 替换记录沿用原交易的额度，丢失记录退回对应交易的额度
+func (job *DepositTopUpJob) loadSpentToday() error {
+	job.day = time.Now().UTC().Format("2006-01-02")
+
+	file, err := os.Open(job.auditLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error opening top-up audit log: %v", err)
+	}
+	defer file.Close()
+
+	spent := make(map[string]*big.Int) // 交易哈希 => 当天预留的额度
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record models.TopUpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.TransactionHash == "" || record.Amount == nil {
+			continue
+		}
+
+		switch record.Status {
+		case models.TopUpSent, "":
+			if time.Unix(record.Time, 0).UTC().Format("2006-01-02") == job.day {
+				spent[record.TransactionHash] = record.Amount
+			}
+		case models.TopUpReplaced:
+			if amount, ok := spent[record.Replaces]; ok {
+				delete(spent, record.Replaces)
+				spent[record.TransactionHash] = amount
+			}
+		case models.TopUpDropped:
+			delete(spent, record.TransactionHash)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, amount := range spent {
+		job.spentToday.Add(job.spentToday, amount)
+	}
+	return nil
+}
+
+// loadPending 读取未确认的补充交易，文件不存在时从空状态开始
+func (job *DepositTopUpJob) loadPending() error {
+	data, err := os.ReadFile(job.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading pending top-ups: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &job.pending); err != nil {
+		return fmt.Errorf("error parsing pending top-ups: %v", err)
+	}
+	for address, pending := range job.pending {
+		if len(pending.TxHashes) == 0 || pending.Amount == nil || pending.GasPrice == nil {
+			return fmt.Errorf("invalid pending top-up for %s in %s", address.Hex(), job.statePath)
+		}
+	}
+	return nil
+}
+
+// savePending 将未确认的补充交易写入状态文件
+func (job *DepositTopUpJob) savePending() error {
+	job.mu.Lock()
+	data, err := json.MarshalIndent(job.pending, "", "  ")
+	job.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding pending top-ups: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(job.statePath), 0o755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	tmpPath := job.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing pending top-ups: %v", err)
+	}
+	return os.Rename(tmpPath, job.statePath)
+}
+
+// weiFromEnv 读取以 wei 为单位的十进制环境变量
+func weiFromEnv(key string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(os.Getenv(key), 10)
+	if !ok || value.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s: %q", key, os.Getenv(key))
+	}
+	return value, nil
+}
@@ -0,0 +1,117 @@
+// dkimKeyFetcher.go
+
+package controllers
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// TXTResolver 查询 DNS TXT 记录的接口，*net.Resolver 满足该接口，测试时可替换为本地 DNS
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DKIMKeyFetcher 从 DNS 获取 DKIM 公钥并写入 PublicKeyOracle
+type DKIMKeyFetcher struct {
+	Oracle   *PublicKeyOracleController
+	Resolver TXTResolver
+}
+
+// NewDKIMKeyFetcher 创建一个新的 DKIMKeyFetcher 实例
+// 设置了 DKIM_DNS_SERVER（host:port）时使用指定的 DNS 服务器，否则使用系统默认解析器
+func NewDKIMKeyFetcher(oracle *PublicKeyOracleController) *DKIMKeyFetcher {
+	var resolver TXTResolver = net.DefaultResolver
+	if server := os.Getenv("DKIM_DNS_SERVER"); server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: 5 * time.Second}
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return &DKIMKeyFetcher{
+		Oracle:   oracle,
+		Resolver: resolver,
+	}
+}
+
+// FetchKey 查询 selector._domainkey.domain 的 TXT 记录，解析出 RSA 公钥的模数和指数
+func (f *DKIMKeyFetcher) FetchKey(domain, selector string) ([]byte, []byte, error) {
+	name := selector + "._domainkey." + domain
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	records, err := f.Resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error looking up %s: %v", name, err)
+	}
+
+	// 一个域名下可能有多条 TXT 记录，取第一条带 p= 标签的 DKIM 记录
+	for _, record := range records {
+		tags := parseDKIMTags(record)
+		encodedKey, ok := tags["p"]
+		if !ok {
+			continue
+		}
+		if keyType, ok := tags["k"]; ok && keyType != "rsa" {
+			return nil, nil, fmt.Errorf("unsupported DKIM key type %q for %s", keyType, name)
+		}
+		if encodedKey == "" {
+			return nil, nil, fmt.Errorf("DKIM key for %s has been revoked", name)
+		}
+
+		publicKey, err := parseDKIMPublicKey(encodedKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing DKIM key for %s: %v", name, err)
+		}
+		return publicKey.N.Bytes(), big.NewInt(int64(publicKey.E)).Bytes(), nil
+	}
+
+	return nil, nil, fmt.Errorf("no DKIM key record found for %s", name)
+}
+
+// SyncKey 从 DNS 获取公钥并通过 SetPublicKey 写入预言机
+func (f *DKIMKeyFetcher) SyncKey(domain, selector string) (string, []byte, []byte, error) {
+	modulus, exponent, err := f.FetchKey(domain, selector)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	txHash, err := f.Oracle.SetPublicKey(domain, selector, modulus, exponent)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return txHash, modulus, exponent, nil
+}
+
+// parseDKIMTags 解析 "v=DKIM1; k=rsa; p=..." 格式的标签列表，标签值中的空白会被去掉
+func parseDKIMTags(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+	}
+	return tags
+}
+
+// parseDKIMPublicKey 解码 p= 标签中的 base64 公钥
+func parseDKIMPublicKey(encodedKey string) (*rsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+	return parseRSAPublicKeyDER(der)
+}
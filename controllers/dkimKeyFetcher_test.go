@@ -0,0 +1,138 @@
+// dkimKeyFetcher_test.go
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// fakeResolver 本地 TXT 解析器，按完整域名返回预设记录
+type fakeResolver struct {
+	records map[string][]string
+	err     error
+	queried []string
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.queried = append(r.queried, name)
+	if r.err != nil {
+		return nil, r.err
+	}
+	records, ok := r.records[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func generateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	return key
+}
+
+func TestFetchKeyParsesTXTRecord(t *testing.T) {
+	key := generateRSAKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+	pkcs1 := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+	encoded := base64.StdEncoding.EncodeToString(pkix)
+
+	tests := []struct {
+		name    string
+		records []string
+	}{
+		{"pkix", []string{"v=DKIM1; k=rsa; p=" + encoded}},
+		{"pkcs1", []string{"v=DKIM1; p=" + base64.StdEncoding.EncodeToString(pkcs1)}},
+		// 长记录在 DNS 中按 255 字节分段，拼接后 p= 值中可能带有空白
+		{"whitespace", []string{"v=DKIM1; k=rsa;\n p=" + encoded[:60] + " \t" + encoded[60:]}},
+		{"skips other records", []string{"v=spf1 include:_spf.example.com ~all", "v=DKIM1; k=rsa; p=" + encoded}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": tt.records}}
+			fetcher := &DKIMKeyFetcher{Resolver: resolver}
+
+			modulus, exponent, err := fetcher.FetchKey("example.com", "s1")
+			if err != nil {
+				t.Fatalf("FetchKey returned error: %v", err)
+			}
+			if !bytes.Equal(modulus, key.N.Bytes()) {
+				t.Errorf("modulus mismatch")
+			}
+			if new(big.Int).SetBytes(exponent).Int64() != int64(key.E) {
+				t.Errorf("exponent = %x, want %x", exponent, key.E)
+			}
+			if len(resolver.queried) != 1 || resolver.queried[0] != "s1._domainkey.example.com" {
+				t.Errorf("queried %v, want [s1._domainkey.example.com]", resolver.queried)
+			}
+		})
+	}
+}
+
+func TestFetchKeyFailures(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating EC key: %v", err)
+	}
+	ecDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("error encoding EC key: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		resolver *fakeResolver
+		wantErr  string
+	}{
+		{"lookup error", &fakeResolver{err: errors.New("i/o timeout")}, "error looking up s1._domainkey.example.com"},
+		{"no such record", &fakeResolver{}, "error looking up"},
+		{"no DKIM record", &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": {"v=spf1 -all"}}}, "no DKIM key record found"},
+		{"revoked", &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": {"v=DKIM1; p="}}}, "has been revoked"},
+		{"unsupported key type", &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": {"v=DKIM1; k=ed25519; p=AAAA"}}}, "unsupported DKIM key type"},
+		{"invalid base64", &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": {"v=DKIM1; p=!!!"}}}, "invalid base64"},
+		{"not a key", &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": {"v=DKIM1; p=" + base64.StdEncoding.EncodeToString([]byte("garbage"))}}}, "invalid RSA public key"},
+		{"not RSA", &fakeResolver{records: map[string][]string{"s1._domainkey.example.com": {"v=DKIM1; p=" + base64.StdEncoding.EncodeToString(ecDER)}}}, "not an RSA public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &DKIMKeyFetcher{Resolver: tt.resolver}
+			_, _, err := fetcher.FetchKey("example.com", "s1")
+			if err == nil {
+				t.Fatalf("FetchKey succeeded, want error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseDKIMTags(t *testing.T) {
+	tags := parseDKIMTags("v=DKIM1; k=rsa; t=y; p=MIGf MA0G\r\n CSqG;  novalue; ")
+	want := map[string]string{"v": "DKIM1", "k": "rsa", "t": "y", "p": "MIGfMA0GCSqG"}
+	if len(tags) != len(want) {
+		t.Fatalf("tags = %v, want %v", tags, want)
+	}
+	for name, value := range want {
+		if tags[name] != value {
+			t.Errorf("tag %s = %q, want %q", name, tags[name], value)
+		}
+	}
+}
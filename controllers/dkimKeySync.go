@@ -0,0 +1,205 @@
+// dkimKeySync.go
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	defaultDKIMSyncInterval  = time.Hour
+	defaultDKIMSyncStatePath = "./data/dkim_sync.json"
+)
+
+// DKIMKeySync 定期比较预言机中的 DKIM 公钥与 DNS 记录，发现轮换时更新预言机并记录历史
+type DKIMKeySync struct {
+	fetcher   *DKIMKeyFetcher
+	interval  time.Duration
+	statePath string
+
+	mu    sync.Mutex
+	state models.DKIMSyncState
+}
+
+// NewDKIMKeySync 创建一个新的 DKIMKeySync 实例
+// 需要跟踪的密钥从 DKIM_TRACKED_KEYS（domain:selector,domain:selector）和历史状态文件中读取
+func NewDKIMKeySync(fetcher *DKIMKeyFetcher) (*DKIMKeySync, error) {
+	interval := defaultDKIMSyncInterval
+	if seconds, err := strconv.Atoi(os.Getenv("DKIM_SYNC_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	statePath := os.Getenv("DKIM_SYNC_STATE_PATH")
+	if statePath == "" {
+		statePath = defaultDKIMSyncStatePath
+	}
+
+	keySync := &DKIMKeySync{
+		fetcher:   fetcher,
+		interval:  interval,
+		statePath: statePath,
+	}
+	if err := keySync.load(); err != nil {
+		return nil, err
+	}
+
+	if trackedKeys := os.Getenv("DKIM_TRACKED_KEYS"); trackedKeys != "" {
+		for _, item := range strings.Split(trackedKeys, ",") {
+			domain, selector, found := strings.Cut(strings.TrimSpace(item), ":")
+			if !found || domain == "" || selector == "" {
+				return nil, fmt.Errorf("invalid entry in DKIM_TRACKED_KEYS: %q", item)
+			}
+			keySync.addTracked(domain, selector)
+		}
+	}
+	return keySync, nil
+}
+
+// Run 启动定时同步，阻塞运行
+func (s *DKIMKeySync) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncAll()
+		<-ticker.C
+	}
+}
+
+// Track 添加一个需要跟踪的 (domain, selector)
+func (s *DKIMKeySync) Track(domain, selector string) error {
+	s.addTracked(domain, selector)
+	return s.save()
+}
+
+// Tracked 返回当前跟踪的所有密钥
+func (s *DKIMKeySync) Tracked() []models.DKIMKeyID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.DKIMKeyID(nil), s.state.Tracked...)
+}
+
+// Rotations 返回所有轮换历史
+func (s *DKIMKeySync) Rotations() []models.DKIMRotation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.DKIMRotation(nil), s.state.Rotations...)
+}
+
+// SyncAll 检查所有跟踪的密钥
+func (s *DKIMKeySync) SyncAll() {
+	for _, key := range s.Tracked() {
+		rotation, err := s.SyncOne(key.Domain, key.Selector)
+		if err != nil {
+			log.Printf("Failed to sync DKIM key %s._domainkey.%s: %v", key.Selector, key.Domain, err)
+			continue
+		}
+		if rotation != nil {
+			log.Printf("DKIM key %s._domainkey.%s rotated in transaction %s", key.Selector, key.Domain, rotation.TransactionHash)
+		}
+	}
+}
+
+// SyncOne 比较预言机与 DNS 中的公钥，只有在密钥变化时才调用 setPublicKey，未变化时返回 nil
+func (s *DKIMKeySync) SyncOne(domain, selector string) (*models.DKIMRotation, error) {
+	modulus, exponent, err := s.fetcher.FetchKey(domain, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	oldModulus, oldExponent, err := s.fetcher.Oracle.GetRSAKey(domain, selector)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(modulus, oldModulus) && bytes.Equal(exponent, oldExponent) {
+		return nil, nil
+	}
+
+	txHash, err := s.fetcher.Oracle.SetPublicKey(domain, selector, modulus, exponent)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := models.DKIMRotation{
+		Domain:          domain,
+		Selector:        selector,
+		OldModulus:      hexutil.Encode(oldModulus),
+		NewModulus:      hexutil.Encode(modulus),
+		NewExponent:     hexutil.Encode(exponent),
+		TransactionHash: txHash,
+		RotatedAt:       time.Now().Unix(),
+	}
+
+	s.mu.Lock()
+	s.state.Rotations = append(s.state.Rotations, rotation)
+	s.mu.Unlock()
+
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save DKIM sync state: %v", err)
+	}
+	return &rotation, nil
+}
+
+// addTracked 添加跟踪项，已存在时忽略
+func (s *DKIMKeySync) addTracked(domain, selector string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.state.Tracked {
+		if key.Domain == domain && key.Selector == selector {
+			return
+		}
+	}
+	s.state.Tracked = append(s.state.Tracked, models.DKIMKeyID{Domain: domain, Selector: selector})
+}
+
+// load 读取状态文件，文件不存在时从空状态开始
+func (s *DKIMKeySync) load() error {
+	data, err := os.ReadFile(s.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading DKIM sync state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return fmt.Errorf("error parsing DKIM sync state: %v", err)
+	}
+	return nil
+}
+
+// save 将状态写入文件
+func (s *DKIMKeySync) save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding DKIM sync state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0o755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	tmpPath := s.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing DKIM sync state: %v", err)
+	}
+	return os.Rename(tmpPath, s.statePath)
+}
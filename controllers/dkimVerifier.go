@@ -0,0 +1,339 @@
+// dkimVerifier.go
+
+package controllers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bundler/models"
+)
+
+var whitespaceRun = regexp.MustCompile(`[ \t]+`)
+
+// DKIMKeySource 按 domain 和 selector 查询 DKIM 公钥，PublicKeyOracleController 实现了该接口
+type DKIMKeySource interface {
+	GetRSAKey(domain, selector string) ([]byte, []byte, error)
+}
+
+// DKIMVerifier 在链下验证邮件的 DKIM 签名，公钥从 PublicKeyOracle 读取
+type DKIMVerifier struct {
+	Oracle DKIMKeySource
+	now    func() time.Time // 检查 x= 过期时间时使用的时钟，为空时使用 time.Now
+}
+
+// NewDKIMVerifier 创建一个新的 DKIMVerifier 实例
+func NewDKIMVerifier(oracle DKIMKeySource) *DKIMVerifier {
+	return &DKIMVerifier{Oracle: oracle}
+}
+
+// rawHeader 邮件中的一个原始头部，Raw 保留折行和结尾的 CRLF
+type rawHeader struct {
+	Name string
+	Raw  string
+}
+
+// Verify 解析 RFC 5322 邮件并验证其中的每个 DKIM-Signature
+// 邮件格式错误时返回 ErrCodeInvalidParams，读取公钥失败时返回内部错误，签名本身的问题写入各结果的 Error 字段
+func (v *DKIMVerifier) Verify(message []byte) ([]models.DKIMVerification, error) {
+	headers, body, err := splitMessage(message)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+
+	// RFC 5322 只允许一个 From，多个 From 时签名覆盖的和邮件客户端显示的可能不是同一个
+	fromCount := 0
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, "From") {
+			fromCount++
+		}
+	}
+	if fromCount > 1 {
+		return nil, NewBundlerError(ErrCodeInvalidParams, "message has %d From headers", fromCount)
+	}
+
+	var results []models.DKIMVerification
+	for _, header := range headers {
+		if !strings.EqualFold(header.Name, "DKIM-Signature") {
+			continue
+		}
+		result, err := v.verifySignature(header, headers, body)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil, NewBundlerError(ErrCodeInvalidParams, "message has no DKIM-Signature header")
+	}
+	return results, nil
+}
+
+// verifySignature 验证单个 DKIM-Signature，签名的问题写入结果的 Error 字段，只有读取公钥失败时返回错误
+func (v *DKIMVerifier) verifySignature(signature rawHeader, headers []rawHeader, body []byte) (models.DKIMVerification, error) {
+	result := models.DKIMVerification{}
+	tags := parseDKIMTags(headerValue(signature.Raw))
+
+	result.Domain = tags["d"]
+	result.Selector = tags["s"]
+	result.Algorithm = tags["a"]
+	result.Canonicalization = tags["c"]
+	if tags["v"] != "1" {
+		result.Error = "unsupported DKIM-Signature version"
+		return result, nil
+	}
+	if result.Domain == "" || result.Selector == "" || tags["h"] == "" || tags["bh"] == "" || tags["b"] == "" {
+		result.Error = "DKIM-Signature is missing a required tag"
+		return result, nil
+	}
+
+	// RFC 6376 5.4：From 必须被签名
+	signedNames := strings.Split(tags["h"], ":")
+	fromSigned := false
+	for _, name := range signedNames {
+		if strings.EqualFold(strings.TrimSpace(name), "From") {
+			fromSigned = true
+		}
+	}
+	if !fromSigned {
+		result.Error = "DKIM-Signature does not cover the From header"
+		return result, nil
+	}
+
+	// t= 为签名时间，x= 为过期时间，过期的签名视为无效
+	if errMessage := v.checkTimestamps(tags, &result); errMessage != "" {
+		result.Error = errMessage
+		return result, nil
+	}
+
+	var hashFunc crypto.Hash
+	var newHash func() hash.Hash
+	switch result.Algorithm {
+	case "rsa-sha256":
+		hashFunc, newHash = crypto.SHA256, sha256.New
+	case "rsa-sha1":
+		hashFunc, newHash = crypto.SHA1, sha1.New
+	default:
+		result.Error = fmt.Sprintf("unsupported algorithm %q", result.Algorithm)
+		return result, nil
+	}
+
+	// c= 缺省为 simple/simple，只写一个时 body 使用 simple
+	headerCanon, bodyCanon := "simple", "simple"
+	if result.Canonicalization != "" {
+		parts := strings.SplitN(result.Canonicalization, "/", 2)
+		headerCanon = parts[0]
+		if len(parts) == 2 {
+			bodyCanon = parts[1]
+		}
+	}
+
+	// 校验 body hash
+	canonicalBody := canonicalizeBody(body, bodyCanon)
+	if length, ok := tags["l"]; ok {
+		l, err := strconv.Atoi(length)
+		if err != nil || l < 0 {
+			result.Error = "invalid l= tag"
+			return result, nil
+		}
+		if l < len(canonicalBody) {
+			canonicalBody = canonicalBody[:l]
+		}
+	}
+	bodyHash := newHash()
+	bodyHash.Write(canonicalBody)
+	expectedBodyHash, err := base64.StdEncoding.DecodeString(tags["bh"])
+	if err != nil {
+		result.Error = "invalid bh= tag"
+		return result, nil
+	}
+	result.BodyHashValid = bytes.Equal(bodyHash.Sum(nil), expectedBodyHash)
+
+	// 按 h= 的顺序从下往上选取被签名的头部
+	signedData := newHash()
+	used := make(map[int]bool)
+	for _, name := range signedNames {
+		name = strings.TrimSpace(name)
+		for i := len(headers) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headers[i].Name, name) {
+				continue
+			}
+			used[i] = true
+			signedData.Write([]byte(canonicalizeHeader(headers[i].Raw, headerCanon)))
+			result.SignedData = append(result.SignedData, canonicalizeHeader(headers[i].Raw, headerCanon)...)
+			result.SignedHeaders = append(result.SignedHeaders, models.SignedHeader{
+				Name:  headers[i].Name,
+				Value: strings.TrimSpace(unfold(headerValue(headers[i].Raw))),
+			})
+			break
+		}
+	}
+
+	// DKIM-Signature 自身也参与签名，b= 的值置空且不带结尾的 CRLF
+	signatureHeader := canonicalizeHeader(stripSignatureValue(signature.Raw), headerCanon)
+	signedData.Write([]byte(strings.TrimSuffix(signatureHeader, "\r\n")))
+	result.SignedData = append(result.SignedData, []byte(strings.TrimSuffix(signatureHeader, "\r\n"))...)
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		result.Error = "invalid b= tag"
+		return result, nil
+	}
+	result.Signature = signatureBytes
+
+	modulus, exponent, err := v.Oracle.GetRSAKey(result.Domain, result.Selector)
+	if err != nil {
+		return result, fmt.Errorf("error getting DKIM key for %s._domainkey.%s: %v", result.Selector, result.Domain, err)
+	}
+	if len(modulus) == 0 {
+		result.Error = fmt.Sprintf("no key for %s._domainkey.%s in PublicKeyOracle", result.Selector, result.Domain)
+		return result, nil
+	}
+	publicKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}
+
+	if err := rsa.VerifyPKCS1v15(publicKey, hashFunc, signedData.Sum(nil), signatureBytes); err != nil {
+		result.Error = "signature verification failed"
+		return result, nil
+	}
+	result.SignatureValid = true
+	if !result.BodyHashValid {
+		result.Error = "body hash mismatch"
+	}
+	return result, nil
+}
+
+// checkTimestamps 解析 t= 和 x=，x= 早于 t= 或已经过期时返回错误原因
+func (v *DKIMVerifier) checkTimestamps(tags map[string]string, result *models.DKIMVerification) string {
+	if value, ok := tags["t"]; ok {
+		timestamp, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || timestamp < 0 {
+			return "invalid t= tag"
+		}
+		result.Timestamp = timestamp
+	}
+	if value, ok := tags["x"]; ok {
+		expiration, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || expiration < 0 {
+			return "invalid x= tag"
+		}
+		if result.Timestamp != 0 && expiration < result.Timestamp {
+			return "x= is earlier than t="
+		}
+		result.Expiration = expiration
+	}
+
+	now := time.Now
+	if v.now != nil {
+		now = v.now
+	}
+	if result.Expiration != 0 && now().Unix() > result.Expiration {
+		return "signature expired"
+	}
+	return ""
+}
+
+// splitMessage 将邮件拆分为头部列表和正文，统一使用 CRLF 换行
+func splitMessage(message []byte) ([]rawHeader, []byte, error) {
+	normalized := strings.ReplaceAll(string(message), "\r\n", "\n")
+	normalized = strings.ReplaceAll(normalized, "\n", "\r\n")
+
+	headerPart, body, found := strings.Cut(normalized, "\r\n\r\n")
+	if !found {
+		headerPart, body = strings.TrimSuffix(normalized, "\r\n"), ""
+	}
+
+	var headers []rawHeader
+	for _, line := range strings.Split(headerPart, "\r\n") {
+		if line == "" {
+			continue
+		}
+		// 以空白开头的行是上一个头部的折行
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(headers) == 0 {
+				return nil, nil, fmt.Errorf("malformed message: continuation line before first header")
+			}
+			headers[len(headers)-1].Raw += line + "\r\n"
+			continue
+		}
+		name, _, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed header line: %q", line)
+		}
+		headers = append(headers, rawHeader{Name: strings.TrimRight(name, " \t"), Raw: line + "\r\n"})
+	}
+	return headers, []byte(body), nil
+}
+
+// headerValue 返回原始头部冒号之后的部分
+func headerValue(raw string) string {
+	_, value, _ := strings.Cut(raw, ":")
+	return value
+}
+
+// unfold 去掉头部中的折行
+func unfold(value string) string {
+	return strings.ReplaceAll(value, "\r\n", "")
+}
+
+// canonicalizeHeader 按 RFC 6376 3.4.1/3.4.2 规范化单个头部，结果以 CRLF 结尾
+func canonicalizeHeader(raw, canon string) string {
+	if canon != "relaxed" {
+		return raw
+	}
+	name, value, _ := strings.Cut(raw, ":")
+	value = whitespaceRun.ReplaceAllString(unfold(value), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+// canonicalizeBody 按 RFC 6376 3.4.3/3.4.4 规范化正文
+func canonicalizeBody(body []byte, canon string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if canon == "relaxed" {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(whitespaceRun.ReplaceAllString(line, " "), " ")
+		}
+	}
+
+	// 去掉结尾的空行
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if canon == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// stripSignatureValue 将 DKIM-Signature 中 b= 标签的值置空，其余内容保持不变
+func stripSignatureValue(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		tagName, _, found := strings.Cut(part, "=")
+		if found && strings.TrimSpace(tagName) == "b" {
+			parts[i] = part[:strings.Index(part, "=")+1]
+			// 保留原始头部结尾的 CRLF
+			if i == len(parts)-1 && strings.HasSuffix(part, "\r\n") {
+				parts[i] += "\r\n"
+			}
+		}
+	}
+	return name + ":" + strings.Join(parts, ";")
+}
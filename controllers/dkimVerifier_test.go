@@ -0,0 +1,350 @@
+// dkimVerifier_test.go
+
+package controllers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKeySource 本地 DKIM 公钥来源，按 selector 和 domain 返回预设的公钥
+type fakeKeySource struct {
+	keys map[string]*rsa.PublicKey
+	err  error
+}
+
+func (s *fakeKeySource) GetRSAKey(domain, selector string) ([]byte, []byte, error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	key, ok := s.keys[selector+"._domainkey."+domain]
+	if !ok {
+		return nil, nil, nil
+	}
+	return key.N.Bytes(), big.NewInt(int64(key.E)).Bytes(), nil
+}
+
+// signDKIM 根据手写的规范化正文和头部计算 bh= 与 b=，替换邮件中的 {bh} 和 {b}
+// canonicalHeaders 以去掉 b= 值、不带结尾 CRLF 的 DKIM-Signature 结束，与被测的规范化实现相互独立
+func signDKIM(t *testing.T, key *rsa.PrivateKey, message, canonicalBody, canonicalHeaders string) string {
+	t.Helper()
+	bodyHash := sha256.Sum256([]byte(canonicalBody))
+	bh := base64.StdEncoding.EncodeToString(bodyHash[:])
+
+	digest := sha256.Sum256([]byte(strings.ReplaceAll(canonicalHeaders, "{bh}", bh)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	b := base64.StdEncoding.EncodeToString(signature)
+	// b= 的值按长度折行，验证时需要忽略其中的空白
+	b = b[:40] + "\r\n\t " + b[40:]
+
+	message = strings.ReplaceAll(message, "{bh}", bh)
+	return strings.ReplaceAll(message, "{b}", b)
+}
+
+func newTestDKIMVerifier(key *rsa.PrivateKey, now int64) *DKIMVerifier {
+	return &DKIMVerifier{
+		Oracle: &fakeKeySource{keys: map[string]*rsa.PublicKey{"s1._domainkey.example.com": &key.PublicKey}},
+		now:    func() time.Time { return time.Unix(now, 0) },
+	}
+}
+
+func TestCanonicalizeRFC6376Example(t *testing.T) {
+	// RFC 6376 3.4.5 的示例
+	headers := []string{"A: X\r\n", "B : Y\t\r\n\tZ  \r\n"}
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+
+	relaxed := canonicalizeHeader(headers[0], "relaxed") + canonicalizeHeader(headers[1], "relaxed")
+	if relaxed != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed headers = %q", relaxed)
+	}
+	simple := canonicalizeHeader(headers[0], "simple") + canonicalizeHeader(headers[1], "simple")
+	if simple != "A: X\r\nB : Y\t\r\n\tZ  \r\n" {
+		t.Errorf("simple headers = %q", simple)
+	}
+
+	if got := string(canonicalizeBody(body, "relaxed")); got != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", got)
+	}
+	if got := string(canonicalizeBody(body, "simple")); got != " C \r\nD \t E\r\n" {
+		t.Errorf("simple body = %q", got)
+	}
+
+	// 空正文：simple 为一个 CRLF，relaxed 为空
+	if got := string(canonicalizeBody(nil, "simple")); got != "\r\n" {
+		t.Errorf("simple empty body = %q", got)
+	}
+	if got := canonicalizeBody([]byte("\r\n\r\n"), "relaxed"); len(got) != 0 {
+		t.Errorf("relaxed empty body = %q", got)
+	}
+}
+
+func TestStripSignatureValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"DKIM-Signature: v=1; bh=abc; b=xyz\r\n", "DKIM-Signature: v=1; bh=abc; b=\r\n"},
+		{"DKIM-Signature: v=1; b=xy\r\n\t z; bh=abc\r\n", "DKIM-Signature: v=1; b=; bh=abc\r\n"},
+		{"DKIM-Signature: v=1; bh=abc;\r\n\tb=xy\r\n\t z\r\n", "DKIM-Signature: v=1; bh=abc;\r\n\tb=\r\n"},
+	}
+	for _, tt := range tests {
+		if got := stripSignatureValue(tt.raw); got != tt.want {
+			t.Errorf("stripSignatureValue(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestDKIMVerify(t *testing.T) {
+	key := generateRSAKey(t)
+
+	tests := []struct {
+		name             string
+		message          string
+		canonicalBody    string
+		canonicalHeaders string
+		tamper           func(message string) string
+		wantValid        bool
+		wantBodyValid    bool
+		wantError        string
+		wantSubject      string
+	}{
+		{
+			name: "relaxed/relaxed with folded headers",
+			message: "From: Alice <alice@example.com>\r\n" +
+				"To: bob@example.org\r\n" +
+				"Subject:  Recover   account\r\n\t0xabc\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1;\r\n\th=from:subject; bh={bh};\r\n\tb={b}\r\n" +
+				"\r\n" +
+				"Hello  world \r\n\r\n\r\n",
+			canonicalBody: "Hello world\r\n",
+			canonicalHeaders: "from:Alice <alice@example.com>\r\n" +
+				"subject:Recover account 0xabc\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from:subject; bh={bh}; b=",
+			wantValid: true, wantBodyValid: true, wantSubject: "Recover   account\t0xabc",
+		},
+		{
+			name: "simple/simple",
+			message: "From: alice@example.com\r\n" +
+				"Subject: Recover  0xabc\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=simple/simple; d=example.com; s=s1; h=From:Subject;\r\n bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello  world \r\n\r\n\r\n",
+			canonicalBody: "Hello  world \r\n",
+			canonicalHeaders: "From: alice@example.com\r\n" +
+				"Subject: Recover  0xabc\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=simple/simple; d=example.com; s=s1; h=From:Subject;\r\n bh={bh}; b=",
+			wantValid: true, wantBodyValid: true, wantSubject: "Recover  0xabc",
+		},
+		{
+			name: "simple headers reject whitespace changes",
+			message: "From: alice@example.com\r\n" +
+				"Subject: Recover 0xabc\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; h=from:subject; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "From: alice@example.com\r\n" +
+				"Subject: Recover 0xabc\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; h=from:subject; bh={bh}; b=",
+			tamper: func(message string) string {
+				return strings.Replace(message, "Subject: Recover 0xabc", "Subject: Recover  0xabc", 1)
+			},
+			wantBodyValid: true, wantError: "signature verification failed",
+		},
+		{
+			name: "l= limits the signed body",
+			message: "From: alice@example.com\r\n" +
+				"Subject: hi\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed; d=example.com; s=s1; l=7; h=from:subject; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\nappended later\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "from:alice@example.com\r\n" +
+				"subject:hi\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed; d=example.com; s=s1; l=7; h=from:subject; bh={bh}; b=",
+			wantValid: true, wantBodyValid: true, wantSubject: "hi",
+		},
+		{
+			name: "missing and over-signed headers contribute nothing",
+			message: "From: alice@example.com\r\n" +
+				"Subject: hi\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from:subject:reply-to:subject; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "from:alice@example.com\r\n" +
+				"subject:hi\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from:subject:reply-to:subject; bh={bh}; b=",
+			wantValid: true, wantBodyValid: true, wantSubject: "hi",
+		},
+		{
+			name: "duplicate header uses the bottom-most instance",
+			message: "Subject: injected 0xdef\r\n" +
+				"From: alice@example.com\r\n" +
+				"Subject: original\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from:subject; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "from:alice@example.com\r\n" +
+				"subject:original\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from:subject; bh={bh}; b=",
+			wantValid: true, wantBodyValid: true, wantSubject: "original",
+		},
+		{
+			name: "body modified",
+			message: "From: alice@example.com\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "from:alice@example.com\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; h=from; bh={bh}; b=",
+			tamper:    func(message string) string { return strings.Replace(message, "Hello", "Hullo", 1) },
+			wantValid: true, wantError: "body hash mismatch",
+		},
+		{
+			name: "unexpired x=",
+			message: "From: alice@example.com\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; t=1000; x=3000; h=from; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "from:alice@example.com\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; t=1000; x=3000; h=from; bh={bh}; b=",
+			wantValid: true, wantBodyValid: true,
+		},
+		{
+			name: "expired x=",
+			message: "From: alice@example.com\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; t=1000; x=1500; h=from; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody: "Hello\r\n",
+			canonicalHeaders: "from:alice@example.com\r\n" +
+				"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; t=1000; x=1500; h=from; bh={bh}; b=",
+			wantError: "signature expired",
+		},
+		{
+			name: "x= before t=",
+			message: "From: alice@example.com\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; t=1900; x=1800; h=from; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody:    "Hello\r\n",
+			canonicalHeaders: "",
+			wantError:        "x= is earlier than t=",
+		},
+		{
+			name: "From not signed",
+			message: "From: alice@example.com\r\n" +
+				"Subject: hi\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; h=subject; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody:    "Hello\r\n",
+			canonicalHeaders: "",
+			wantError:        "does not cover the From header",
+		},
+		{
+			name: "unknown selector",
+			message: "From: alice@example.com\r\n" +
+				"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s2; h=from; bh={bh}; b={b}\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			canonicalBody:    "Hello\r\n",
+			canonicalHeaders: "",
+			wantBodyValid:    true,
+			wantError:        "no key for s2._domainkey.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := signDKIM(t, key, tt.message, tt.canonicalBody, tt.canonicalHeaders)
+			if tt.tamper != nil {
+				message = tt.tamper(message)
+			}
+
+			results, err := newTestDKIMVerifier(key, 2000).Verify([]byte(message))
+			if err != nil {
+				t.Fatalf("Verify returned error: %v", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			result := results[0]
+			if result.SignatureValid != tt.wantValid || result.BodyHashValid != tt.wantBodyValid {
+				t.Errorf("signatureValid = %v, bodyHashValid = %v, want %v, %v (error %q)",
+					result.SignatureValid, result.BodyHashValid, tt.wantValid, tt.wantBodyValid, result.Error)
+			}
+			if !strings.Contains(result.Error, tt.wantError) || (tt.wantError == "" && result.Error != "") {
+				t.Errorf("error = %q, want %q", result.Error, tt.wantError)
+			}
+			if tt.wantSubject != "" && signedHeader(&result, "Subject") != tt.wantSubject {
+				t.Errorf("signed Subject = %q, want %q", signedHeader(&result, "Subject"), tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestDKIMVerifyTimestamps(t *testing.T) {
+	key := generateRSAKey(t)
+	message := signDKIM(t, key,
+		"From: alice@example.com\r\n"+
+			"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; t=1000; x=3000; h=from; bh={bh}; b={b}\r\n"+
+			"\r\n"+
+			"Hello\r\n",
+		"Hello\r\n",
+		"from:alice@example.com\r\n"+
+			"dkim-signature:v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=s1; t=1000; x=3000; h=from; bh={bh}; b=")
+
+	results, err := newTestDKIMVerifier(key, 2000).Verify([]byte(message))
+	if err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+	if results[0].Timestamp != 1000 || results[0].Expiration != 3000 {
+		t.Errorf("timestamp = %d, expiration = %d, want 1000, 3000", results[0].Timestamp, results[0].Expiration)
+	}
+}
+
+func TestDKIMVerifyErrors(t *testing.T) {
+	key := generateRSAKey(t)
+	signed := "From: alice@example.com\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=s1; h=from; bh=AAAA; b=AAAA\r\n" +
+		"\r\n" +
+		"Hello\r\n"
+
+	tests := []struct {
+		name     string
+		verifier *DKIMVerifier
+		message  string
+		wantCode int
+	}{
+		{"no signature", newTestDKIMVerifier(key, 0), "From: alice@example.com\r\n\r\nHello\r\n", ErrCodeInvalidParams},
+		{"malformed header", newTestDKIMVerifier(key, 0), " folded\r\nFrom: alice@example.com\r\n\r\n", ErrCodeInvalidParams},
+		{"duplicate From", newTestDKIMVerifier(key, 0), "From: mallory@evil.io\r\n" + signed, ErrCodeInvalidParams},
+		{"oracle failure", &DKIMVerifier{Oracle: &fakeKeySource{err: errors.New("connection refused")}}, signed, ErrCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.verifier.Verify([]byte(tt.message))
+			if err == nil {
+				t.Fatal("Verify succeeded, want error")
+			}
+			if code := AsBundlerError(err).Code; code != tt.wantCode {
+				t.Errorf("code = %d, want %d (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
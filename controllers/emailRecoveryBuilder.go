@@ -0,0 +1,352 @@
+// emailRecoveryBuilder.go
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	defaultRecoveryVerificationGas    = 1000000 // 账户在链上验证 DKIM 签名需要较多 gas
+	defaultRecoveryCallGas            = 200000
+	defaultRecoveryPreVerificationGas = 100000
+
+	defaultEmailRecoveryMethod      = "recoverOwner"
+	defaultEmailRecoveryEmailMethod = "recoveryEmailHash"
+	defaultEmailRecoveryProofLayout = "domain,selector,signedData,signature"
+
+	defaultEmailRecoveryMaxAge = 3600 // 恢复邮件的最长有效期（秒）
+	emailRecoveryClockSkew     = 300  // 允许签名时间比本地时间超前的秒数
+)
+
+// addressPattern 匹配 0x 开头的十六进制串，长度和前后边界在 subjectAddresses 中检查
+var addressPattern = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+
+// dkimProofFields 签名字段中可以使用的 DKIM 证明字段及其 ABI 类型
+var dkimProofFields = map[string]string{
+	"domain":     "string", // DKIM 的 d=
+	"selector":   "string", // DKIM 的 s=
+	"signedData": "bytes",  // 规范化后参与签名的头部数据
+	"signature":  "bytes",  // b= 中的 RSA 签名
+	"from":       "string", // 小写的发件人邮箱地址
+}
+
+// EmailRecoveryBuilder 将一封带 DKIM 签名的恢复邮件转换为完整的 PackedUserOperation
+// 邮件主题中需要依次包含账户地址和新 owner 地址，例如 "Recover 0x<account> to 0x<newOwner>"
+// 发件人必须是账户登记的恢复邮箱：账户的 EMAIL_RECOVERY_EMAIL_METHOD 返回 keccak256(小写邮箱地址)
+type EmailRecoveryBuilder struct {
+	UserOps     *UserOpController
+	Verifier    *DKIMVerifier
+	accountAbi  abi.ABI
+	method      string   // 恢复方法，参数为 (address newOwner)
+	emailMethod string   // 返回恢复邮箱哈希的 view 方法
+	proofLayout []string // 签名字段中 abi.encode 的 DKIM 证明字段顺序
+	proofArgs   abi.Arguments
+	maxAge      time.Duration // 签名时间距今的最长时间，超过时拒绝，防止旧邮件被重放
+}
+
+// NewEmailRecoveryBuilder 创建一个新的 EmailRecoveryBuilder 实例，未配置 EmailRecoveryAccount_ABI 时返回 nil
+// 恢复方法、恢复邮箱方法和证明布局分别由 EMAIL_RECOVERY_METHOD、EMAIL_RECOVERY_EMAIL_METHOD、EMAIL_RECOVERY_PROOF_LAYOUT 配置
+// 邮件有效期由 EMAIL_RECOVERY_MAX_AGE（秒）配置
+func NewEmailRecoveryBuilder(userOps *UserOpController, verifier *DKIMVerifier) (*EmailRecoveryBuilder, error) {
+	abiPath := os.Getenv("EmailRecoveryAccount_ABI")
+	if abiPath == "" {
+		return nil, nil
+	}
+	accountAbi, err := loadABI(abiPath)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &EmailRecoveryBuilder{
+		UserOps:     userOps,
+		Verifier:    verifier,
+		accountAbi:  accountAbi,
+		method:      defaultEmailRecoveryMethod,
+		emailMethod: defaultEmailRecoveryEmailMethod,
+		maxAge:      time.Duration(uint64FromEnv("EMAIL_RECOVERY_MAX_AGE", defaultEmailRecoveryMaxAge)) * time.Second,
+	}
+	if value := strings.TrimSpace(os.Getenv("EMAIL_RECOVERY_METHOD")); value != "" {
+		builder.method = value
+	}
+	if value := strings.TrimSpace(os.Getenv("EMAIL_RECOVERY_EMAIL_METHOD")); value != "" {
+		builder.emailMethod = value
+	}
+
+	method, ok := accountAbi.Methods[builder.method]
+	if !ok || argumentTypes(method.Inputs) != "address" {
+		return nil, fmt.Errorf("EmailRecoveryAccount_ABI must define %s(address newOwner)", builder.method)
+	}
+	emailMethod, ok := accountAbi.Methods[builder.emailMethod]
+	if !ok || len(emailMethod.Inputs) != 0 || argumentTypes(emailMethod.Outputs) != "bytes32" {
+		return nil, fmt.Errorf("EmailRecoveryAccount_ABI must define %s() returns (bytes32)", builder.emailMethod)
+	}
+
+	layout := os.Getenv("EMAIL_RECOVERY_PROOF_LAYOUT")
+	if strings.TrimSpace(layout) == "" {
+		layout = defaultEmailRecoveryProofLayout
+	}
+	for _, field := range strings.Split(layout, ",") {
+		field = strings.TrimSpace(field)
+		typeName, ok := dkimProofFields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown EMAIL_RECOVERY_PROOF_LAYOUT field %q", field)
+		}
+		builder.proofLayout = append(builder.proofLayout, field)
+		builder.proofArgs = append(builder.proofArgs, abi.Argument{Name: field, Type: mustNewType(typeName)})
+	}
+	return builder, nil
+}
+
+// Build 验证邮件的 DKIM 签名并构建恢复用的 UserOp
+func (b *EmailRecoveryBuilder) Build(message []byte) (*models.PackedUserOperation, error) {
+	verifications, err := b.Verifier.Verify(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// 取第一个验证通过的签名
+	var verification *models.DKIMVerification
+	for i := range verifications {
+		if verifications[i].SignatureValid && verifications[i].BodyHashValid {
+			verification = &verifications[i]
+			break
+		}
+	}
+	if verification == nil {
+		return nil, fmt.Errorf("email has no valid DKIM signature")
+	}
+
+	// From 和 Subject 必须被签名，且 From 的域名必须与 DKIM 的 d= 一致
+	from, subject := signedHeader(verification, "From"), signedHeader(verification, "Subject")
+	if from == "" || subject == "" {
+		return nil, fmt.Errorf("From and Subject must be covered by the DKIM signature")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid From header: %v", err)
+	}
+	if _, fromDomain, _ := strings.Cut(sender.Address, "@"); !strings.EqualFold(fromDomain, verification.Domain) {
+		return nil, fmt.Errorf("From domain %s does not match DKIM domain %s", fromDomain, verification.Domain)
+	}
+
+	// 签名时间必须在有效期内，否则任何截获过的旧恢复邮件都能被重新提交
+	if err := checkEmailFreshness(verification, b.maxAge, time.Now()); err != nil {
+		return nil, err
+	}
+
+	addresses := subjectAddresses(subject)
+	if len(addresses) < 2 {
+		return nil, fmt.Errorf("subject must contain the account address and the new owner address")
+	}
+	account, newOwner := addresses[0], addresses[1]
+
+	// 账户必须已经部署，恢复调用由账户自身执行
+	code, err := b.UserOps.Client.CodeAt(context.Background(), account, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting account code: %v", err)
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("account %s is not deployed", account.Hex())
+	}
+
+	// 任何域名下有效签名的邮件都能通过 DKIM 验证，只有账户登记的恢复邮箱才能恢复该账户
+	fromAddress := strings.ToLower(sender.Address)
+	if err := b.checkRecoveryEmail(account, fromAddress); err != nil {
+		return nil, err
+	}
+
+	callData, err := b.accountAbi.Pack(b.method, newOwner)
+	if err != nil {
+		return nil, fmt.Errorf("error packing data: %v", err)
+	}
+
+	// 签名字段携带 DKIM 证明，由账户通过 PublicKeyOracle 在链上验证
+	signature, err := b.packDKIMProof(verification, fromAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := b.UserOps.getNonce(account, big.NewInt(0))
+	if err != nil {
+		return nil, err
+	}
+
+	gasFees, err := b.suggestGasFees()
+	if err != nil {
+		return nil, err
+	}
+
+	verificationGas := uint64FromEnv("EMAIL_RECOVERY_VERIFICATION_GAS", defaultRecoveryVerificationGas)
+	callGas := uint64FromEnv("EMAIL_RECOVERY_CALL_GAS", defaultRecoveryCallGas)
+	preVerificationGas := uint64FromEnv("EMAIL_RECOVERY_PRE_VERIFICATION_GAS", defaultRecoveryPreVerificationGas)
+
+	return &models.PackedUserOperation{
+		Sender:             account,
+		Nonce:              nonce,
+		InitCode:           "0x",
+		CallData:           hexutil.Encode(callData),
+		AccountGasLimits:   hexutil.Encode(packUint128Pair(new(big.Int).SetUint64(verificationGas), new(big.Int).SetUint64(callGas))),
+		PreVerificationGas: new(big.Int).SetUint64(preVerificationGas),
+		GasFees:            hexutil.Encode(gasFees),
+		PaymasterAndData:   "0x",
+		Signature:          hexutil.Encode(signature),
+	}, nil
+}
+
+// suggestGasFees 根据当前 base fee 和建议小费计算 maxPriorityFeePerGas | maxFeePerGas
+func (b *EmailRecoveryBuilder) suggestGasFees() ([]byte, error) {
+	tip, err := b.UserOps.Client.SuggestGasTipCap(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error getting gas tip cap: %v", err)
+	}
+
+	header, err := b.UserOps.Client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting latest header: %v", err)
+	}
+
+	maxFee := new(big.Int).Set(tip)
+	if header.BaseFee != nil {
+		maxFee.Add(maxFee, new(big.Int).Mul(header.BaseFee, big.NewInt(2)))
+	}
+	return packUint128Pair(tip, maxFee), nil
+}
+
+// signedHeader 返回 DKIM 签名覆盖的指定头部的值
+func signedHeader(verification *models.DKIMVerification, name string) string {
+	for _, header := range verification.SignedHeaders {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// checkEmailFreshness 检查 DKIM 的 t= 和被签名的 Date 头部，至少要有一个，且都必须在 maxAge 之内、不能超前于本地时间
+func checkEmailFreshness(verification *models.DKIMVerification, maxAge time.Duration, now time.Time) error {
+	var timestamps []time.Time
+	if verification.Timestamp != 0 {
+		timestamps = append(timestamps, time.Unix(verification.Timestamp, 0))
+	}
+	if value := signedHeader(verification, "Date"); value != "" {
+		date, err := mail.ParseDate(value)
+		if err != nil {
+			return fmt.Errorf("invalid Date header: %v", err)
+		}
+		timestamps = append(timestamps, date)
+	}
+	if len(timestamps) == 0 {
+		return fmt.Errorf("DKIM signature must carry t= or cover the Date header")
+	}
+
+	for _, timestamp := range timestamps {
+		if timestamp.After(now.Add(emailRecoveryClockSkew * time.Second)) {
+			return fmt.Errorf("email is dated in the future: %s", timestamp.UTC().Format(time.RFC3339))
+		}
+		if now.Sub(timestamp) > maxAge {
+			return fmt.Errorf("email is older than %s: %s", maxAge, timestamp.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// subjectAddresses 按顺序返回主题中独立出现的地址
+// 更长的十六进制串或前面紧接字母数字的串不算地址，避免从其中截取出前 40 个字符
+func subjectAddresses(subject string) []common.Address {
+	var addresses []common.Address
+	for _, match := range addressPattern.FindAllStringIndex(subject, -1) {
+		if match[1]-match[0] != 2+2*common.AddressLength {
+			continue
+		}
+		if match[0] > 0 && isAlphanumeric(subject[match[0]-1]) {
+			continue
+		}
+		if match[1] < len(subject) && isAlphanumeric(subject[match[1]]) {
+			continue
+		}
+		addresses = append(addresses, common.HexToAddress(subject[match[0]:match[1]]))
+	}
+	return addresses
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// checkRecoveryEmail 调用账户的恢复邮箱方法，确认发件人是账户登记的恢复邮箱
+func (b *EmailRecoveryBuilder) checkRecoveryEmail(account common.Address, fromAddress string) error {
+	data, err := b.accountAbi.Pack(b.emailMethod)
+	if err != nil {
+		return fmt.Errorf("error packing data: %v", err)
+	}
+	result, err := b.UserOps.Client.CallContract(context.Background(), ethereum.CallMsg{To: &account, Data: data}, nil)
+	if err != nil {
+		return fmt.Errorf("error calling %s: %v", b.emailMethod, err)
+	}
+	if len(result) != common.HashLength {
+		return fmt.Errorf("account %s returned no recovery email hash", account.Hex())
+	}
+	if common.BytesToHash(result) != crypto.Keccak256Hash([]byte(fromAddress)) {
+		return fmt.Errorf("%s is not the recovery email of account %s", fromAddress, account.Hex())
+	}
+	return nil
+}
+
+// packDKIMProof 按 EMAIL_RECOVERY_PROOF_LAYOUT 将 DKIM 证明编码为 abi.encode(...)，默认 (string domain, string selector, bytes signedData, bytes signature)
+func (b *EmailRecoveryBuilder) packDKIMProof(verification *models.DKIMVerification, fromAddress string) ([]byte, error) {
+	values := make([]interface{}, len(b.proofLayout))
+	for i, field := range b.proofLayout {
+		switch field {
+		case "domain":
+			values[i] = verification.Domain
+		case "selector":
+			values[i] = verification.Selector
+		case "signedData":
+			values[i] = verification.SignedData
+		case "signature":
+			values[i] = verification.Signature
+		case "from":
+			values[i] = fromAddress
+		}
+	}
+
+	proof, err := b.proofArgs.Pack(values...)
+	if err != nil {
+		return nil, fmt.Errorf("error packing DKIM proof: %v", err)
+	}
+	return proof, nil
+}
+
+// packUint128Pair 将两个 uint128 打包为 bytes32，高 128 位在前
+func packUint128Pair(high, low *big.Int) []byte {
+	packed := make([]byte, 32)
+	high.FillBytes(packed[:16])
+	low.FillBytes(packed[16:])
+	return packed
+}
+
+// uint64FromEnv 读取无符号整数环境变量，未配置或格式错误时使用默认值
+func uint64FromEnv(key string, defaultValue uint64) uint64 {
+	value, err := strconv.ParseUint(os.Getenv(key), 10, 64)
+	if err != nil || value == 0 {
+		return defaultValue
+	}
+	return value
+}
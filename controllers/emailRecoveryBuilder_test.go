@@ -0,0 +1,84 @@
+// emailRecoveryBuilder_test.go
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestSubjectAddresses(t *testing.T) {
+	account := "0x1111111111111111111111111111111111111111"
+	owner := "0x2222222222222222222222222222222222222222"
+
+	tests := []struct {
+		name    string
+		subject string
+		want    []string
+	}{
+		{"two addresses", "Recover " + account + " to " + owner, []string{account, owner}},
+		{"punctuation boundaries", "Recover (" + account + "), " + owner + ".", []string{account, owner}},
+		{"longer hex string", "Recover " + account + "ab to " + owner, []string{owner}},
+		{"transaction hash", "Recover 0x" + strings.Repeat("ab", 32) + " to " + owner, []string{owner}},
+		{"preceded by alphanumeric", "Recover a" + account + " to " + owner, []string{owner}},
+		{"followed by a letter", "Recover " + account + "z to " + owner, []string{owner}},
+		{"nested prefix", "Recover 0x0" + account + " to " + owner, []string{owner}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := subjectAddresses(tt.subject)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != common.HexToAddress(tt.want[i]) {
+					t.Errorf("address %d = %s, want %s", i, got[i].Hex(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckEmailFreshness(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	withDate := func(timestamp int64, date string) *models.DKIMVerification {
+		verification := &models.DKIMVerification{Timestamp: timestamp}
+		if date != "" {
+			verification.SignedHeaders = []models.SignedHeader{{Name: "Date", Value: date}}
+		}
+		return verification
+	}
+
+	tests := []struct {
+		name         string
+		verification *models.DKIMVerification
+		wantError    string
+	}{
+		{"recent t=", withDate(now.Add(-10*time.Minute).Unix(), ""), ""},
+		{"recent Date", withDate(0, "Fri, 2 Jan 2026 12:50:00 +0100"), ""},
+		{"no timestamp", withDate(0, ""), "must carry t="},
+		{"old t=", withDate(now.Add(-2*time.Hour).Unix(), ""), "older than"},
+		{"old Date with recent t=", withDate(now.Unix(), "Thu, 1 Jan 2026 12:00:00 +0000"), "older than"},
+		{"future Date", withDate(0, "Fri, 2 Jan 2026 13:00:00 +0000"), "in the future"},
+		{"invalid Date", withDate(0, "yesterday"), "invalid Date header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEmailFreshness(tt.verification, time.Hour, now)
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Fatalf("error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
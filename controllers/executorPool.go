@@ -0,0 +1,263 @@
+// executorPool.go
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"bundler/config"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ErrNoIdleExecutor 等待超时后仍没有空闲且余额充足的执行者
+var ErrNoIdleExecutor = errors.New("no idle executor with sufficient balance")
+
+// ErrBundlingPaused 所有执行者余额都低于临界值，暂停打包
+var ErrBundlingPaused = errors.New("bundling paused: executor balance below critical level")
+
+const defaultExecutorAcquireTimeout = time.Minute
+
+// executorState 执行者的占用状态
+type executorState int
+
+const (
+	executorIdle     executorState = iota // 空闲，可以分配新的 bundle
+	executorReserved                      // 已分配，bundle 交易尚未发出
+	executorInFlight                      // bundle 交易已发出，等待上链
+)
+
+// executor 执行者账户的状态
+type executor struct {
+	signer  Signer
+	balance *big.Int
+	state   executorState
+	txHash  common.Hash // state 为 executorInFlight 时的 bundle 交易
+	paused  bool        // 余额低于临界值时由 BalanceMonitor 暂停
+}
+
+// ExecutorPool 管理多个执行者账户，每个新 bundle 分配给一个空闲执行者，避免单账户 nonce 冲突
+type ExecutorPool struct {
+	client         *ethclient.Client
+	Nonces         *NonceManager
+	minBalance     *big.Int
+	acquireTimeout time.Duration
+
+	mu        sync.Mutex
+	executors []*executor
+	next      int           // 轮询起点，使负载分散到各个执行者
+	idle      chan struct{} // 有执行者被释放时通知等待中的 Acquire
+}
+
+// NewExecutorPool 创建执行者池，包含主签名器和 EXECUTOR_KEYSTORE_PATHS / EXECUTOR_PRIVATE_KEYS 中的额外账户
+// 余额低于 EXECUTOR_MIN_BALANCE（wei）的执行者不会被分配新的 bundle；
+// 所有执行者都在忙时 Acquire 最多等待 EXECUTOR_ACQUIRE_TIMEOUT 秒
+func NewExecutorPool(client *ethclient.Client) (*ExecutorPool, error) {
+	primary, err := currentSigner()
+	if err != nil {
+		return nil, err
+	}
+	signers := []Signer{primary}
+
+	keys, err := config.LoadAdditionalExecutorKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		signers = append(signers, NewLocalSigner(key))
+	}
+
+	minBalance, err := optionalWeiFromEnv("EXECUTOR_MIN_BALANCE")
+	if err != nil {
+		return nil, err
+	}
+
+	acquireTimeout := defaultExecutorAcquireTimeout
+	if seconds, err := strconv.Atoi(os.Getenv("EXECUTOR_ACQUIRE_TIMEOUT")); err == nil && seconds > 0 {
+		acquireTimeout = time.Duration(seconds) * time.Second
+	}
+
+	// 主签名器同时用于预言机、存款和恢复交易，所以与它们共用进程内唯一的 NonceManager
+	pool := &ExecutorPool{
+		client:         client,
+		Nonces:         SharedNonceManager(client),
+		minBalance:     minBalance,
+		acquireTimeout: acquireTimeout,
+		idle:           make(chan struct{}, 1),
+	}
+	seen := make(map[common.Address]bool)
+	for _, signer := range signers {
+		if seen[signer.Address()] {
+			continue
+		}
+		seen[signer.Address()] = true
+		pool.executors = append(pool.executors, &executor{signer: signer})
+	}
+	return pool, nil
+}
+
+// Acquire 选出一个空闲且余额充足的执行者并标记为已分配，所有执行者都在忙时等待其中一个被释放
+// 交易发出后调用 MarkInFlight，发送失败时调用 Release
+func (p *ExecutorPool) Acquire() (Signer, error) {
+	deadline := time.Now().Add(p.acquireTimeout)
+	for {
+		signer, err := p.tryAcquire()
+		if err != nil || signer != nil {
+			return signer, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, ErrNoIdleExecutor
+		}
+		// 释放通知可能被其他等待者取走，所以同时定期重试
+		if remaining > time.Second {
+			remaining = time.Second
+		}
+		select {
+		case <-p.idle:
+		case <-time.After(remaining):
+		}
+	}
+}
+
+// tryAcquire 依次尝试各个空闲执行者，余额在持锁之外查询；没有可用执行者时返回 nil, nil
+func (p *ExecutorPool) tryAcquire() (Signer, error) {
+	tried := make(map[*executor]bool)
+	for {
+		candidate, err := p.reserveCandidate(tried)
+		if candidate == nil || err != nil {
+			return nil, err
+		}
+		tried[candidate] = true
+
+		balance, err := p.client.BalanceAt(context.Background(), candidate.signer.Address(), nil)
+		if err != nil {
+			p.Release(candidate.signer.Address())
+			return nil, fmt.Errorf("error getting executor balance: %v", err)
+		}
+
+		p.mu.Lock()
+		candidate.balance = balance
+		p.mu.Unlock()
+		if balance.Cmp(p.minBalance) >= 0 {
+			return candidate.signer, nil
+		}
+		p.Release(candidate.signer.Address())
+	}
+}
+
+// reserveCandidate 按轮询顺序找到一个未尝试过的空闲执行者并标记为已分配
+func (p *ExecutorPool) reserveCandidate(tried map[*executor]bool) (*executor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.allPaused() {
+		return nil, ErrBundlingPaused
+	}
+	for i := 0; i < len(p.executors); i++ {
+		candidate := p.executors[(p.next+i)%len(p.executors)]
+		if tried[candidate] || candidate.paused || candidate.state != executorIdle {
+			continue
+		}
+		candidate.state = executorReserved
+		p.next = (p.next + i + 1) % len(p.executors)
+		return candidate, nil
+	}
+	return nil, nil
+}
+
+// MarkInFlight 记录执行者正在等待确认的 bundle 交易，启动恢复时也用它标记快照中的执行者
+func (p *ExecutorPool) MarkInFlight(address common.Address, txHash common.Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.executors {
+		if e.signer.Address() == address {
+			e.state = executorInFlight
+			e.txHash = txHash
+		}
+	}
+}
+
+// Release 将已分配但未发出交易的执行者标记为空闲
+func (p *ExecutorPool) Release(address common.Address) {
+	p.release(address, nil)
+}
+
+// ReleaseBundle 在 bundle 上链或被丢弃后释放执行者，执行者已在处理其他 bundle 时不做处理
+func (p *ExecutorPool) ReleaseBundle(address common.Address, txHash common.Hash) {
+	p.release(address, &txHash)
+}
+
+// release txHash 不为 nil 时只释放仍在等待该交易的执行者
+func (p *ExecutorPool) release(address common.Address, txHash *common.Hash) {
+	p.mu.Lock()
+	released := false
+	for _, e := range p.executors {
+		if e.signer.Address() != address || (txHash != nil && (e.state != executorInFlight || e.txHash != *txHash)) {
+			continue
+		}
+		e.state = executorIdle
+		e.txHash = common.Hash{}
+		released = true
+	}
+	p.mu.Unlock()
+
+	if released {
+		select {
+		case p.idle <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Addresses 返回池中所有执行者地址
+func (p *ExecutorPool) Addresses() []common.Address {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addresses := make([]common.Address, 0, len(p.executors))
+	for _, e := range p.executors {
+		addresses = append(addresses, e.signer.Address())
+	}
+	return addresses
+}
+
+// SetPaused 暂停或恢复向某个执行者分配 bundle
+func (p *ExecutorPool) SetPaused(address common.Address, paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.executors {
+		if e.signer.Address() == address {
+			e.paused = paused
+		}
+	}
+}
+
+// Paused 判断是否所有执行者都已暂停
+func (p *ExecutorPool) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.allPaused()
+}
+
+// allPaused 调用方需持有 p.mu
+func (p *ExecutorPool) allPaused() bool {
+	for _, e := range p.executors {
+		if !e.paused {
+			return false
+		}
+	}
+	return len(p.executors) > 0
+}
@@ -0,0 +1,196 @@
+// mempool.go
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	defaultMempoolSnapshotPath = "./data/mempool.json"
+)
+
+// Mempool 记录已经打包发送、等待上链的 UserOp，并支持快照到磁盘
+type Mempool struct {
+	mu           sync.Mutex
+	inFlight     map[common.Hash]*models.InFlightBundle
+	reserved     map[common.Address]*big.Int // 正在发送、尚未记录为在途的 UserOp 占用的 paymaster 存款
+	snapshotPath string
+}
+
+// NewMempool 创建一个新的 Mempool 实例
+func NewMempool(snapshotPath string) *Mempool {
+	if snapshotPath == "" {
+		snapshotPath = defaultMempoolSnapshotPath
+	}
+	return &Mempool{
+		inFlight:     make(map[common.Hash]*models.InFlightBundle),
+		reserved:     make(map[common.Address]*big.Int),
+		snapshotPath: snapshotPath,
+	}
+}
+
+// Track 记录一笔已发送的 bundle 交易及其执行者
+func (m *Mempool) Track(txHash common.Hash, executor common.Address, userOps []models.PackedUserOperation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[txHash] = &models.InFlightBundle{
+		TxHash:   txHash,
+		Executor: executor,
+		UserOps:  userOps,
+		SentAt:   time.Now().Unix(),
+	}
+}
+
+// Remove 移除已确认或已放弃的 bundle 交易，返回该交易之前是否在记录中
+func (m *Mempool) Remove(txHash common.Hash) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.inFlight[txHash]
+	delete(m.inFlight, txHash)
+	return ok
+}
+
+// Get 返回指定交易的在途 bundle 副本
+func (m *Mempool) Get(txHash common.Hash) (models.InFlightBundle, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bundle, ok := m.inFlight[txHash]
+	if !ok {
+		return models.InFlightBundle{}, false
+	}
+	return *bundle, true
+}
+
+// InFlight 返回当前所有在途 bundle 的副本
+func (m *Mempool) InFlight() []models.InFlightBundle {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bundles := make([]models.InFlightBundle, 0, len(m.inFlight))
+	for _, bundle := range m.inFlight {
+		bundles = append(bundles, *bundle)
+	}
+	return bundles
+}
+
+// HasUserOp 判断是否已有相同 sender 和 nonce 的 UserOp 在途
+func (m *Mempool) HasUserOp(sender common.Address, nonce *big.Int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, bundle := range m.inFlight {
+		for _, userOp := range bundle.UserOps {
+			if userOp.Sender == sender && userOp.Nonce != nil && userOp.Nonce.Cmp(nonce) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReservePaymaster 在 paymaster 存款足以覆盖所有在途和预留 UserOp 的最大费用时预留 cost，否则返回已占用的金额和 false
+func (m *Mempool) ReservePaymaster(paymaster common.Address, cost, deposit *big.Int) (*big.Int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := new(big.Int)
+	if reserved, ok := m.reserved[paymaster]; ok {
+		pending.Add(pending, reserved)
+	}
+	for _, bundle := range m.inFlight {
+		for _, userOp := range bundle.UserOps {
+			opPaymaster, opCost, err := userOpMaxCost(userOp)
+			if err == nil && opCost != nil && opPaymaster == paymaster {
+				pending.Add(pending, opCost)
+			}
+		}
+	}
+
+	if new(big.Int).Add(pending, cost).Cmp(deposit) > 0 {
+		return pending, false
+	}
+	if _, ok := m.reserved[paymaster]; !ok {
+		m.reserved[paymaster] = big.NewInt(0)
+	}
+	m.reserved[paymaster].Add(m.reserved[paymaster], cost)
+	return pending, true
+}
+
+// ReleasePaymaster 释放 ReservePaymaster 预留的金额，UserOp 发送失败或已记录为在途后调用
+func (m *Mempool) ReleasePaymaster(paymaster common.Address, cost *big.Int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reserved, ok := m.reserved[paymaster]
+	if !ok {
+		return
+	}
+	reserved.Sub(reserved, cost)
+	if reserved.Sign() <= 0 {
+		delete(m.reserved, paymaster)
+	}
+}
+
+// Save 将在途 bundle 写入快照文件，先写临时文件再重命名，避免写一半被中断
+func (m *Mempool) Save() error {
+	snapshot := models.MempoolSnapshot{
+		InFlight: m.InFlight(),
+		SavedAt:  time.Now().Unix(),
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding mempool snapshot: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.snapshotPath), 0o755); err != nil {
+		return fmt.Errorf("error creating snapshot directory: %v", err)
+	}
+
+	tmpPath := m.snapshotPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing mempool snapshot: %v", err)
+	}
+	if err := os.Rename(tmpPath, m.snapshotPath); err != nil {
+		return fmt.Errorf("error replacing mempool snapshot: %v", err)
+	}
+	return nil
+}
+
+// Load 从快照文件恢复在途 bundle，文件不存在时视为空池
+func (m *Mempool) Load() error {
+	data, err := os.ReadFile(m.snapshotPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading mempool snapshot: %v", err)
+	}
+
+	var snapshot models.MempoolSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("error parsing mempool snapshot: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range snapshot.InFlight {
+		bundle := snapshot.InFlight[i]
+		m.inFlight[bundle.TxHash] = &bundle
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// nonceManager.go
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// NonceManager 在本地为发送账户分配连续的 nonce，避免同一账户连续发交易时重复获取到相同的 nonce
+// 已分配但尚未广播成功的 nonce 记为未完成，只有没有未完成的 nonce 时才会从节点重新同步
+type NonceManager struct {
+	client   *ethclient.Client
+	mu       sync.Mutex
+	accounts map[common.Address]*accountNonces
+}
+
+// accountNonces 单个账户的本地 nonce 记录
+type accountNonces struct {
+	next        uint64              // 下一个分配的 nonce
+	outstanding map[uint64]struct{} // 已分配但尚未广播成功的 nonce
+	stale       bool                // 本地记录可能与链上不一致，等未完成的 nonce 全部结束后重新同步
+}
+
+var (
+	sharedNoncesOnce sync.Once
+	sharedNonces     *NonceManager
+)
+
+// SharedNonceManager 返回进程内共享的 NonceManager，首次调用时用传入的 client 创建
+// 执行者私钥同时用于 bundle、预言机、存款和恢复交易，这些路径都必须通过它分配 nonce，否则会签出相同的 nonce
+func SharedNonceManager(client *ethclient.Client) *NonceManager {
+	sharedNoncesOnce.Do(func() {
+		sharedNonces = NewNonceManager(client)
+	})
+	return sharedNonces
+}
+
+// NewNonceManager 创建一个新的 NonceManager 实例
+func NewNonceManager(client *ethclient.Client) *NonceManager {
+	return &NonceManager{
+		client:   client,
+		accounts: make(map[common.Address]*accountNonces),
+	}
+}
+
+// Next 返回账户下一个可用的 nonce 并记为未完成，首次使用或记录过期且没有未完成的 nonce 时从节点同步
+// 调用方必须在交易广播成功后调用 Sent，失败时调用 Release
+func (m *NonceManager) Next(address common.Address) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.accounts[address]
+	if !ok || (account.stale && len(account.outstanding) == 0) {
+		pending, err := m.client.PendingNonceAt(context.Background(), address)
+		if err != nil {
+			return 0, fmt.Errorf("error getting nonce: %v", err)
+		}
+		if !ok {
+			account = &accountNonces{outstanding: make(map[uint64]struct{})}
+			m.accounts[address] = account
+		}
+		account.next = pending
+		account.stale = false
+	}
+
+	nonce := account.next
+	account.next++
+	account.outstanding[nonce] = struct{}{}
+	return nonce, nil
+}
+
+// Sent 交易已被节点接受，nonce 不再是未完成状态
+func (m *NonceManager) Sent(address common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if account, ok := m.accounts[address]; ok {
+		delete(account.outstanding, nonce)
+	}
+}
+
+// Release 交易签名或发送失败，归还分配的 nonce
+// 如果它是最后分配的 nonce 直接回退，否则链上会留下空洞，等其他未完成的 nonce 结束后重新同步
+func (m *NonceManager) Release(address common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.accounts[address]
+	if !ok {
+		return
+	}
+	delete(account.outstanding, nonce)
+	if nonce+1 == account.next {
+		account.next = nonce
+		return
+	}
+	account.stale = true
+}
+
+// Reset 已广播的交易被节点丢弃，本地记录不再可靠，等没有未完成的 nonce 时再从节点同步
+// 不会立即丢弃记录，否则其他协程已签名但尚未广播的 nonce 可能被重新分配
+func (m *NonceManager) Reset(address common.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if account, ok := m.accounts[address]; ok {
+		account.stale = true
+	}
+}
@@ -0,0 +1,310 @@
+// oracleKeyIndexer.go
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	defaultOracleIndexInterval      = 15 * time.Second
+	defaultOracleIndexStatePath     = "./data/oracle_keys.json"
+	defaultOracleIndexConfirmations = 12
+	oracleIndexBlocksPerRound       = 500 // 每轮最多扫描的区块数
+	oracleIndexBatchSize            = 50  // 每个 JSON-RPC 批量请求包含的区块数
+)
+
+// rpcBlock 只解析索引需要的区块字段，避免未知交易类型导致整个区块解码失败
+type rpcBlock struct {
+	Transactions []struct {
+		Hash  common.Hash     `json:"hash"`
+		To    *common.Address `json:"to"`
+		Input hexutil.Bytes   `json:"input"`
+	} `json:"transactions"`
+}
+
+// OracleKeyIndexer 扫描发往 PublicKeyOracle 的 setPublicKey 交易，在本地维护公钥目录
+// 合约的 publicKeys 是嵌套 mapping，链上无法枚举，setPublicKey 也不发事件，所以按交易索引；
+// 只能看到直接发往合约的交易，通过其他合约内部调用 setPublicKey 设置的公钥不会被索引
+// 只索引已有 ORACLE_INDEX_CONFIRMATIONS 个确认的区块，避免重组后目录中留下已被回滚的公钥
+type OracleKeyIndexer struct {
+	rpcClient     *rpc.Client
+	client        *ethclient.Client
+	oracleAbi     abi.ABI
+	interval      time.Duration
+	confirmations uint64
+	statePath     string
+
+	mu    sync.Mutex
+	state models.OracleIndexState
+}
+
+// NewOracleKeyIndexer 创建一个新的 OracleKeyIndexer 实例，起始区块由 ORACLE_INDEX_START_BLOCK 配置
+// 没有历史状态且未配置起始区块时返回 nil，不启动索引
+func NewOracleKeyIndexer() (*OracleKeyIndexer, error) {
+	statePath := os.Getenv("ORACLE_INDEX_STATE_PATH")
+	if statePath == "" {
+		statePath = defaultOracleIndexStatePath
+	}
+	startBlock := os.Getenv("ORACLE_INDEX_START_BLOCK")
+	if _, err := os.Stat(statePath); os.IsNotExist(err) && startBlock == "" {
+		return nil, nil
+	}
+
+	rpcClient, err := rpc.Dial(os.Getenv("RPC_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to the Ethereum client: %w", err)
+	}
+
+	oracleAbi, err := loadPublicKeyOracleABI()
+	if err != nil {
+		return nil, err
+	}
+
+	interval := defaultOracleIndexInterval
+	if seconds, err := strconv.Atoi(os.Getenv("ORACLE_INDEX_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	confirmations, err := indexConfirmationsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	indexer := &OracleKeyIndexer{
+		rpcClient:     rpcClient,
+		client:        ethclient.NewClient(rpcClient),
+		oracleAbi:     oracleAbi,
+		interval:      interval,
+		confirmations: confirmations,
+		statePath:     statePath,
+		state:         models.OracleIndexState{Keys: make(map[string]models.OracleKeyRecord)},
+	}
+	if err := indexer.load(); err != nil {
+		return nil, err
+	}
+
+	// 没有历史状态时从配置的起始区块（通常是预言机合约的部署区块）开始
+	if indexer.state.NextBlock == 0 && startBlock != "" {
+		indexer.state.NextBlock, err = strconv.ParseUint(startBlock, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ORACLE_INDEX_START_BLOCK: %v", err)
+		}
+	}
+	return indexer, nil
+}
+
+// Run 定期扫描新区块，阻塞运行
+func (idx *OracleKeyIndexer) Run() {
+	ticker := time.NewTicker(idx.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := idx.indexRound(); err != nil {
+			log.Printf("Failed to index PublicKeyOracle transactions: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Keys 按 domain、selector 排序后分页返回公钥目录，domain 不为空时只返回该域名
+func (idx *OracleKeyIndexer) Keys(domain string, page, pageSize int) ([]models.OracleKeyRecord, int) {
+	idx.mu.Lock()
+	records := make([]models.OracleKeyRecord, 0, len(idx.state.Keys))
+	for _, record := range idx.state.Keys {
+		if domain == "" || record.Domain == domain {
+			records = append(records, record)
+		}
+	}
+	idx.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Domain != records[j].Domain {
+			return records[i].Domain < records[j].Domain
+		}
+		return records[i].Selector < records[j].Selector
+	})
+
+	total := len(records)
+	start := (page - 1) * pageSize
+	if start >= total {
+		return []models.OracleKeyRecord{}, total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	return records[start:end], total
+}
+
+// indexRound 扫描一批已确认的区块，每个批量请求处理完后保存状态
+func (idx *OracleKeyIndexer) indexRound() error {
+	latest, err := idx.client.BlockNumber(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting block number: %v", err)
+	}
+	if latest < idx.confirmations {
+		return nil
+	}
+	confirmed := latest - idx.confirmations
+
+	for scanned := 0; idx.state.NextBlock <= confirmed && scanned < oracleIndexBlocksPerRound; scanned += oracleIndexBatchSize {
+		fromBlock := idx.state.NextBlock
+		toBlock := fromBlock + oracleIndexBatchSize - 1
+		if toBlock > confirmed {
+			toBlock = confirmed
+		}
+		if err := idx.indexBlocks(fromBlock, toBlock); err != nil {
+			return err
+		}
+
+		idx.mu.Lock()
+		idx.state.NextBlock = toBlock + 1
+		idx.mu.Unlock()
+		if err := idx.save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBlocks 用一个 JSON-RPC 批量请求取回 [fromBlock, toBlock] 的区块并索引其中的 setPublicKey 交易
+func (idx *OracleKeyIndexer) indexBlocks(fromBlock, toBlock uint64) error {
+	blocks := make([]rpcBlock, toBlock-fromBlock+1)
+	batch := make([]rpc.BatchElem, len(blocks))
+	for i := range batch {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(fromBlock + uint64(i)), true},
+			Result: &blocks[i],
+		}
+	}
+	if err := idx.rpcClient.BatchCallContext(context.Background(), batch); err != nil {
+		return fmt.Errorf("error getting blocks %d-%d: %v", fromBlock, toBlock, err)
+	}
+
+	oracle := common.HexToAddress(publicKeyOracleAddress)
+	setPublicKey := idx.oracleAbi.Methods["setPublicKey"]
+	for i, elem := range batch {
+		blockNumber := fromBlock + uint64(i)
+		if elem.Error != nil {
+			return fmt.Errorf("error getting block %d: %v", blockNumber, elem.Error)
+		}
+		for _, tx := range blocks[i].Transactions {
+			if tx.To == nil || *tx.To != oracle || len(tx.Input) < 4 || !bytes.Equal(tx.Input[:4], setPublicKey.ID) {
+				continue
+			}
+			if err := idx.indexTransaction(blockNumber, tx.Hash, tx.Input); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexTransaction 解码一笔 setPublicKey 交易，交易执行失败时忽略
+func (idx *OracleKeyIndexer) indexTransaction(blockNumber uint64, txHash common.Hash, input []byte) error {
+	receipt, err := idx.client.TransactionReceipt(context.Background(), txHash)
+	if err != nil {
+		return fmt.Errorf("error getting receipt of %s: %v", txHash.Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil
+	}
+
+	args, err := idx.oracleAbi.Methods["setPublicKey"].Inputs.Unpack(input[4:])
+	if err != nil {
+		log.Printf("Skipping malformed setPublicKey transaction %s: %v", txHash.Hex(), err)
+		return nil
+	}
+	domain, _ := args[0].(string)
+	selector, _ := args[1].(string)
+	modulus, _ := args[2].([]byte)
+	exponent, _ := args[3].([]byte)
+
+	fingerprint := sha256.Sum256(append(append([]byte{}, modulus...), exponent...))
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.state.Keys[domain+":"+selector] = models.OracleKeyRecord{
+		Domain:          domain,
+		Selector:        selector,
+		Fingerprint:     hex.EncodeToString(fingerprint[:]),
+		SetAtBlock:      blockNumber,
+		TransactionHash: txHash.Hex(),
+	}
+	return nil
+}
+
+// load 读取状态文件，文件不存在时从空状态开始
+func (idx *OracleKeyIndexer) load() error {
+	data, err := os.ReadFile(idx.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading oracle index state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &idx.state); err != nil {
+		return fmt.Errorf("error parsing oracle index state: %v", err)
+	}
+	if idx.state.Keys == nil {
+		idx.state.Keys = make(map[string]models.OracleKeyRecord)
+	}
+	return nil
+}
+
+// save 将状态写入文件
+func (idx *OracleKeyIndexer) save() error {
+	idx.mu.Lock()
+	data, err := json.MarshalIndent(idx.state, "", "  ")
+	idx.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding oracle index state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(idx.statePath), 0o755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	tmpPath := idx.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing oracle index state: %v", err)
+	}
+	return os.Rename(tmpPath, idx.statePath)
+}
+
+// indexConfirmationsFromEnv 读取事件索引使用的确认数 ORACLE_INDEX_CONFIRMATIONS，预言机目录和恢复状态共用
+func indexConfirmationsFromEnv() (uint64, error) {
+	value := os.Getenv("ORACLE_INDEX_CONFIRMATIONS")
+	if value == "" {
+		return defaultOracleIndexConfirmations, nil
+	}
+	confirmations, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ORACLE_INDEX_CONFIRMATIONS: %v", err)
+	}
+	return confirmations, nil
+}
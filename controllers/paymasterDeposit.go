@@ -0,0 +1,82 @@
+// paymasterDeposit.go
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// paymasterDataOffset paymasterAndData 中 paymaster 地址与两个 gas limit 的长度
+const paymasterDataOffset = common.AddressLength + 32
+
+// userOpMaxCost 计算使用 paymaster 的 UserOp 的最大 gas 费用，没有 paymaster 时 cost 为 nil
+// 最大费用 = (verificationGasLimit + callGasLimit + preVerificationGas + paymaster 验证与 postOp gas) * maxFeePerGas
+func userOpMaxCost(userOp models.PackedUserOperation) (common.Address, *big.Int, error) {
+	_, _, accountGasLimits, gasFees, paymasterAndData, _, err := decodeUserOp(userOp)
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+	if len(paymasterAndData) == 0 {
+		return common.Address{}, nil, nil
+	}
+	if len(paymasterAndData) < paymasterDataOffset {
+		return common.Address{}, nil, NewBundlerError(ErrCodeInvalidParams, "paymasterAndData must be at least %d bytes", paymasterDataOffset)
+	}
+	if len(accountGasLimits) != 32 || len(gasFees) != 32 {
+		return common.Address{}, nil, NewBundlerError(ErrCodeInvalidParams, "accountGasLimits and gasFees must be 32 bytes")
+	}
+
+	paymaster := common.BytesToAddress(paymasterAndData[:common.AddressLength])
+	totalGas := new(big.Int).SetBytes(accountGasLimits[:16])
+	totalGas.Add(totalGas, new(big.Int).SetBytes(accountGasLimits[16:]))
+	totalGas.Add(totalGas, userOp.PreVerificationGas)
+	totalGas.Add(totalGas, new(big.Int).SetBytes(paymasterAndData[common.AddressLength:common.AddressLength+16]))
+	totalGas.Add(totalGas, new(big.Int).SetBytes(paymasterAndData[common.AddressLength+16:paymasterDataOffset]))
+	return paymaster, totalGas.Mul(totalGas, new(big.Int).SetBytes(gasFees[16:])), nil
+}
+
+// reservePaymasterDeposit 检查 paymaster 在 EntryPoint 中的存款是否足以覆盖所有在途 UserOp 加上本次的最大费用，
+// 足够时预留本次费用，调用方在发送结束后调用 Mempool.ReleasePaymaster；没有 paymaster 时返回的 cost 为 nil
+func (ctrl *UserOpController) reservePaymasterDeposit(contractAbi abi.ABI, userOp models.PackedUserOperation) (common.Address, *big.Int, error) {
+	paymaster, cost, err := userOpMaxCost(userOp)
+	if err != nil || cost == nil {
+		return paymaster, nil, err
+	}
+
+	deposit, err := ctrl.paymasterDeposit(contractAbi, paymaster)
+	if err != nil {
+		return paymaster, nil, err
+	}
+	if pending, ok := ctrl.Mempool.ReservePaymaster(paymaster, cost, deposit); !ok {
+		return paymaster, nil, NewBundlerError(ErrCodePaymaster, "paymaster %s deposit %v is too low: pending ops need %v, this op needs up to %v", paymaster.Hex(), deposit, pending, cost)
+	}
+	return paymaster, cost, nil
+}
+
+// paymasterDeposit 查询 paymaster 在 EntryPoint 中的存款 balanceOf
+func (ctrl *UserOpController) paymasterDeposit(contractAbi abi.ABI, paymaster common.Address) (*big.Int, error) {
+	data, err := contractAbi.Pack("balanceOf", paymaster)
+	if err != nil {
+		return nil, fmt.Errorf("error packing data: %v", err)
+	}
+
+	toAddress := common.HexToAddress(entryPointAddress)
+	result, err := ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: data}, nil)
+	if err != nil {
+		return nil, withRevertReason(contractAbi, "error calling balanceOf", err)
+	}
+
+	var deposit *big.Int
+	if err := contractAbi.UnpackIntoInterface(&deposit, "balanceOf", result); err != nil {
+		return nil, fmt.Errorf("error unpacking result: %v", err)
+	}
+	return deposit, nil
+}
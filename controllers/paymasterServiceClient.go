@@ -0,0 +1,185 @@
+// paymasterServiceClient.go
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const defaultPaymasterServiceTimeout = 10 * time.Second
+
+// PaymasterServiceClient ERC-7677 paymaster web service 客户端
+// 为没有 paymasterAndData 的 UserOp 依次调用 pm_getPaymasterStubData 和 pm_getPaymasterData 获取 paymaster 数据
+type PaymasterServiceClient struct {
+	allowedURLs []*url.URL // 允许访问的服务，scheme 和 host 必须完全一致，path 按前缀匹配；为空时不访问任何服务
+	timeout     time.Duration
+}
+
+// NewPaymasterServiceClient 根据 PAYMASTER_SERVICE_URLS（逗号分隔的 URL）和 PAYMASTER_SERVICE_TIMEOUT 创建客户端
+func NewPaymasterServiceClient() (*PaymasterServiceClient, error) {
+	var allowedURLs []*url.URL
+	for _, item := range strings.Split(os.Getenv("PAYMASTER_SERVICE_URLS"), ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		allowed, err := parseServiceURL(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PAYMASTER_SERVICE_URLS entry %q: %v", item, err)
+		}
+		allowedURLs = append(allowedURLs, allowed)
+	}
+
+	timeout := defaultPaymasterServiceTimeout
+	if seconds, err := strconv.Atoi(os.Getenv("PAYMASTER_SERVICE_TIMEOUT")); err == nil && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	return &PaymasterServiceClient{allowedURLs: allowedURLs, timeout: timeout}, nil
+}
+
+// Fill 向 paymaster 服务请求数据并返回填好 paymasterAndData 的 UserOp
+// 先取 stub 数据用于估算，stub 不是最终数据时再用 stub 的 gas limit 请求最终数据
+// 账户签名覆盖 paymasterAndData，因此 UserOp 的签名需要基于最终数据生成
+func (pc *PaymasterServiceClient) Fill(userOp models.PackedUserOperation, service models.PaymasterService, chainID *big.Int) (models.PackedUserOperation, error) {
+	if !pc.allowed(service.URL) {
+		return userOp, NewBundlerError(ErrCodePaymaster, "paymaster service %s is not allowed", service.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pc.timeout)
+	defer cancel()
+
+	client, err := rpc.DialContext(ctx, service.URL)
+	if err != nil {
+		return userOp, fmt.Errorf("error connecting to paymaster service: %v", err)
+	}
+	defer client.Close()
+
+	unpacked, err := unpackUserOp(userOp)
+	if err != nil {
+		return userOp, err
+	}
+	entryPoint := common.HexToAddress(entryPointAddress)
+	var serviceContext interface{} = service.Context
+	if len(service.Context) == 0 {
+		serviceContext = map[string]interface{}{}
+	}
+
+	var stub models.PaymasterStubData
+	if err := client.CallContext(ctx, &stub, "pm_getPaymasterStubData", unpacked, entryPoint, (*hexutil.Big)(chainID), serviceContext); err != nil {
+		return userOp, paymasterServiceError("pm_getPaymasterStubData", err)
+	}
+	if stub.PaymasterVerificationGasLimit == nil {
+		return userOp, NewBundlerError(ErrCodePaymaster, "paymaster service returned no paymasterVerificationGasLimit")
+	}
+	postOpGasLimit := big.NewInt(0)
+	if stub.PaymasterPostOpGasLimit != nil {
+		postOpGasLimit = stub.PaymasterPostOpGasLimit.ToInt()
+	}
+
+	paymaster, paymasterData := stub.Paymaster, []byte(stub.PaymasterData)
+	if !stub.IsFinal {
+		unpacked.Paymaster = &stub.Paymaster
+		unpacked.PaymasterVerificationGasLimit = stub.PaymasterVerificationGasLimit
+		unpacked.PaymasterPostOpGasLimit = (*hexutil.Big)(postOpGasLimit)
+		unpacked.PaymasterData = stub.PaymasterData
+
+		var final models.PaymasterData
+		if err := client.CallContext(ctx, &final, "pm_getPaymasterData", unpacked, entryPoint, (*hexutil.Big)(chainID), serviceContext); err != nil {
+			return userOp, paymasterServiceError("pm_getPaymasterData", err)
+		}
+		paymaster, paymasterData = final.Paymaster, final.PaymasterData
+	}
+
+	// paymasterAndData = paymaster | 验证 gas (16) | postOp gas (16) | paymasterData
+	paymasterAndData := append(paymaster.Bytes(), packUint128Pair(stub.PaymasterVerificationGasLimit.ToInt(), postOpGasLimit)...)
+	paymasterAndData = append(paymasterAndData, paymasterData...)
+	userOp.PaymasterAndData = hexutil.Encode(paymasterAndData)
+	return userOp, nil
+}
+
+// allowed 判断服务 URL 是否在允许列表中：scheme 和 host（含端口）完全一致，且 path 在允许的 path 之下
+// 只比较字符串前缀时 https://pm.example.com.evil.io 也能匹配 https://pm.example.com
+func (pc *PaymasterServiceClient) allowed(rawURL string) bool {
+	target, err := parseServiceURL(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range pc.allowedURLs {
+		if target.Scheme != allowed.Scheme || target.Host != allowed.Host {
+			continue
+		}
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if target.Path == prefix || strings.HasPrefix(target.Path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseServiceURL 解析 http(s) 服务 URL，scheme 和 host 转为小写，path 去掉 . 和 .. 段
+func parseServiceURL(rawURL string) (*url.URL, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" || parsed.User != nil {
+		return nil, fmt.Errorf("URL must have a host and no user info")
+	}
+	parsed.Host = strings.ToLower(parsed.Host)
+	if parsed.Path != "" {
+		parsed.Path = path.Clean(parsed.Path)
+	}
+	return parsed, nil
+}
+
+// paymasterServiceError 将 paymaster 服务返回的错误转换为 paymaster 错误码
+func paymasterServiceError(method string, err error) error {
+	return NewBundlerError(ErrCodePaymaster, "%s failed: %v", method, err)
+}
+
+// unpackUserOp 将打包格式的 UserOp 转换为 ERC-7677 使用的非打包格式
+func unpackUserOp(userOp models.PackedUserOperation) (models.UnpackedUserOperation, error) {
+	initCode, callData, accountGasLimits, gasFees, _, signature, err := decodeUserOp(userOp)
+	if err != nil {
+		return models.UnpackedUserOperation{}, err
+	}
+	if len(accountGasLimits) != 32 || len(gasFees) != 32 {
+		return models.UnpackedUserOperation{}, NewBundlerError(ErrCodeInvalidParams, "accountGasLimits and gasFees must be 32 bytes")
+	}
+
+	unpacked := models.UnpackedUserOperation{
+		Sender:               userOp.Sender,
+		Nonce:                (*hexutil.Big)(userOp.Nonce),
+		CallData:             callData,
+		VerificationGasLimit: (*hexutil.Big)(new(big.Int).SetBytes(accountGasLimits[:16])),
+		CallGasLimit:         (*hexutil.Big)(new(big.Int).SetBytes(accountGasLimits[16:])),
+		PreVerificationGas:   (*hexutil.Big)(userOp.PreVerificationGas),
+		MaxPriorityFeePerGas: (*hexutil.Big)(new(big.Int).SetBytes(gasFees[:16])),
+		MaxFeePerGas:         (*hexutil.Big)(new(big.Int).SetBytes(gasFees[16:])),
+		Signature:            signature,
+	}
+	if len(initCode) >= common.AddressLength {
+		factory := common.BytesToAddress(initCode[:common.AddressLength])
+		unpacked.Factory = &factory
+		unpacked.FactoryData = initCode[common.AddressLength:]
+	}
+	return unpacked, nil
+}
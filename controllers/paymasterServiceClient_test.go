@@ -0,0 +1,242 @@
+// paymasterServiceClient_test.go
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// paymasterServiceCall 本地 paymaster 服务收到的一次请求
+type paymasterServiceCall struct {
+	Method string
+	UserOp models.UnpackedUserOperation
+	Params []json.RawMessage
+}
+
+// mockPaymasterService 本地 ERC-7677 服务，results 按方法名给出返回值，值为 *rpcTestError 时返回错误
+func mockPaymasterService(t *testing.T, results map[string]interface{}) (*httptest.Server, *[]paymasterServiceCall) {
+	t.Helper()
+	var calls []paymasterServiceCall
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("error decoding request: %v", err)
+			return
+		}
+
+		call := paymasterServiceCall{Method: request.Method, Params: request.Params}
+		if len(request.Params) > 0 {
+			if err := json.Unmarshal(request.Params[0], &call.UserOp); err != nil {
+				t.Errorf("error decoding userOp: %v", err)
+			}
+		}
+		calls = append(calls, call)
+
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+		switch result := results[request.Method].(type) {
+		case nil:
+			response["error"] = rpcTestError{Code: -32601, Message: "method not found"}
+		case *rpcTestError:
+			response["error"] = result
+		default:
+			response["result"] = result
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func newTestPaymasterServiceClient(t *testing.T, allowedURLs string) *PaymasterServiceClient {
+	t.Helper()
+	t.Setenv("PAYMASTER_SERVICE_URLS", allowedURLs)
+	client, err := NewPaymasterServiceClient()
+	if err != nil {
+		t.Fatalf("NewPaymasterServiceClient returned error: %v", err)
+	}
+	return client
+}
+
+func testUnsponsoredUserOp() models.PackedUserOperation {
+	return models.PackedUserOperation{
+		Sender:             common.HexToAddress("0x00000000000000000000000000000000000000aa"),
+		Nonce:              big.NewInt(3),
+		InitCode:           "0x",
+		CallData:           "0xb61d27f6",
+		AccountGasLimits:   hexutil.Encode(packUint128Pair(big.NewInt(150000), big.NewInt(80000))),
+		PreVerificationGas: big.NewInt(50000),
+		GasFees:            hexutil.Encode(packUint128Pair(big.NewInt(1000000000), big.NewInt(30000000000))),
+		PaymasterAndData:   "0x",
+		Signature:          "0x",
+	}
+}
+
+func TestPaymasterServiceFillStubThenData(t *testing.T) {
+	paymaster := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	server, calls := mockPaymasterService(t, map[string]interface{}{
+		"pm_getPaymasterStubData": map[string]interface{}{
+			"paymaster":                     paymaster,
+			"paymasterData":                 "0x0000",
+			"paymasterVerificationGasLimit": "0x186a0",
+			"paymasterPostOpGasLimit":       "0xc350",
+			"isFinal":                       false,
+		},
+		"pm_getPaymasterData": map[string]interface{}{
+			"paymaster":     paymaster,
+			"paymasterData": "0xcafe",
+		},
+	})
+	client := newTestPaymasterServiceClient(t, server.URL)
+
+	service := models.PaymasterService{URL: server.URL + "/rpc", Context: json.RawMessage(`{"policyId":"p1"}`)}
+	userOp, err := client.Fill(testUnsponsoredUserOp(), service, big.NewInt(11155111))
+	if err != nil {
+		t.Fatalf("Fill returned error: %v", err)
+	}
+
+	// paymaster | 验证 gas 100000 | postOp gas 50000 | 最终数据
+	want := append(paymaster.Bytes(), packUint128Pair(big.NewInt(100000), big.NewInt(50000))...)
+	want = append(want, 0xca, 0xfe)
+	if userOp.PaymasterAndData != hexutil.Encode(want) {
+		t.Errorf("paymasterAndData = %s, want %s", userOp.PaymasterAndData, hexutil.Encode(want))
+	}
+
+	if len(*calls) != 2 || (*calls)[0].Method != "pm_getPaymasterStubData" || (*calls)[1].Method != "pm_getPaymasterData" {
+		t.Fatalf("calls = %+v, want stub then data", *calls)
+	}
+	stubCall, dataCall := (*calls)[0], (*calls)[1]
+	if stubCall.UserOp.CallGasLimit.ToInt().Int64() != 80000 || stubCall.UserOp.VerificationGasLimit.ToInt().Int64() != 150000 {
+		t.Errorf("stub request gas limits = %v / %v", stubCall.UserOp.VerificationGasLimit, stubCall.UserOp.CallGasLimit)
+	}
+	if len(stubCall.Params) != 4 || string(stubCall.Params[3]) != `{"policyId":"p1"}` {
+		t.Errorf("stub request params = %s", stubCall.Params)
+	}
+	// 第二次请求需要带上 stub 返回的 paymaster 字段
+	if dataCall.UserOp.Paymaster == nil || *dataCall.UserOp.Paymaster != paymaster ||
+		dataCall.UserOp.PaymasterVerificationGasLimit.ToInt().Int64() != 100000 {
+		t.Errorf("data request userOp = %+v", dataCall.UserOp)
+	}
+}
+
+func TestPaymasterServiceFillFinalStub(t *testing.T) {
+	paymaster := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	server, calls := mockPaymasterService(t, map[string]interface{}{
+		"pm_getPaymasterStubData": map[string]interface{}{
+			"paymaster":                     paymaster,
+			"paymasterData":                 "0x01",
+			"paymasterVerificationGasLimit": "0x1",
+			"isFinal":                       true,
+		},
+	})
+	client := newTestPaymasterServiceClient(t, server.URL)
+
+	userOp, err := client.Fill(testUnsponsoredUserOp(), models.PaymasterService{URL: server.URL}, big.NewInt(1))
+	if err != nil {
+		t.Fatalf("Fill returned error: %v", err)
+	}
+	want := append(paymaster.Bytes(), packUint128Pair(big.NewInt(1), big.NewInt(0))...)
+	want = append(want, 0x01)
+	if userOp.PaymasterAndData != hexutil.Encode(want) {
+		t.Errorf("paymasterAndData = %s, want %s", userOp.PaymasterAndData, hexutil.Encode(want))
+	}
+	if len(*calls) != 1 {
+		t.Errorf("got %d calls, want only pm_getPaymasterStubData", len(*calls))
+	}
+}
+
+func TestPaymasterServiceFillErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		results map[string]interface{}
+	}{
+		{"stub error", map[string]interface{}{"pm_getPaymasterStubData": &rpcTestError{Code: -32000, Message: "policy rejected"}}},
+		{"missing gas limit", map[string]interface{}{"pm_getPaymasterStubData": map[string]interface{}{"paymasterData": "0x", "isFinal": true}}},
+		{"data error", map[string]interface{}{
+			"pm_getPaymasterStubData": map[string]interface{}{"paymasterVerificationGasLimit": "0x1", "isFinal": false},
+			"pm_getPaymasterData":     &rpcTestError{Code: -32000, Message: "expired"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := mockPaymasterService(t, tt.results)
+			client := newTestPaymasterServiceClient(t, server.URL)
+
+			_, err := client.Fill(testUnsponsoredUserOp(), models.PaymasterService{URL: server.URL}, big.NewInt(1))
+			var bundlerErr *BundlerError
+			if !errors.As(err, &bundlerErr) || bundlerErr.Code != ErrCodePaymaster {
+				t.Errorf("error = %v, want code %d", err, ErrCodePaymaster)
+			}
+		})
+	}
+}
+
+func TestPaymasterServiceRejectsDisallowedURL(t *testing.T) {
+	server, calls := mockPaymasterService(t, map[string]interface{}{})
+	client := newTestPaymasterServiceClient(t, "https://pm.example.com/v1")
+
+	_, err := client.Fill(testUnsponsoredUserOp(), models.PaymasterService{URL: server.URL}, big.NewInt(1))
+	var bundlerErr *BundlerError
+	if !errors.As(err, &bundlerErr) || bundlerErr.Code != ErrCodePaymaster {
+		t.Errorf("error = %v, want code %d", err, ErrCodePaymaster)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("disallowed service was contacted %d times", len(*calls))
+	}
+}
+
+func TestPaymasterServiceAllowed(t *testing.T) {
+	client := newTestPaymasterServiceClient(t, "https://pm.example.com/v1, http://localhost:3000")
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://pm.example.com/v1", true},
+		{"https://pm.example.com/v1/", true},
+		{"https://pm.example.com/v1/rpc?apiKey=abc", true},
+		{"https://PM.Example.com/v1/rpc", true},
+		{"http://localhost:3000", true},
+		{"http://localhost:3000/any/path", true},
+		{"https://pm.example.com.evil.io/v1", false},
+		{"https://evil.io/pm.example.com/v1", false},
+		{"https://pm.example.com@evil.io/v1", false},
+		{"https://user@pm.example.com/v1", false},
+		{"http://pm.example.com/v1", false},
+		{"https://pm.example.com:8443/v1", false},
+		{"https://pm.example.com/v10", false},
+		{"https://pm.example.com/", false},
+		{"https://pm.example.com/v1/../admin", false},
+		{"http://localhost:30001", false},
+		{"ftp://pm.example.com/v1", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		if got := client.allowed(tt.url); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNewPaymasterServiceClientRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"pm.example.com", "ftp://pm.example.com", "https://"} {
+		t.Setenv("PAYMASTER_SERVICE_URLS", entry)
+		if _, err := NewPaymasterServiceClient(); err == nil {
+			t.Errorf("NewPaymasterServiceClient accepted %q", entry)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+// recoveryController.go
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	defaultRecoveryPollInterval = 15 * time.Second
+	defaultRecoveryStatePath    = "./data/recovery.json"
+	recoveryLogBlockRange       = 5000 // 单次 eth_getLogs 查询的最大区块跨度
+	recoveryGasLimitMargin      = 20   // executeRecovery 的 gas limit 在估算结果上增加的百分比
+)
+
+// recoveryModuleInterface 恢复模块的方法名和事件名，不同的恢复模块实现可以通过环境变量改名
+// 方法参数和事件字段的布局是固定的，启动时通过 validate 检查 RecoveryModule_ABI 是否满足
+type recoveryModuleInterface struct {
+	ProposeMethod  string // (address account, address newOwner)
+	ConfirmMethod  string // (address account, bytes32 recoveryId)
+	ExecuteMethod  string // (address account, bytes32 recoveryId)
+	ProposedEvent  string // (address indexed account, bytes32 indexed recoveryId, address indexed guardian, address newOwner)
+	ConfirmedEvent string // (address indexed account, bytes32 indexed recoveryId, address indexed guardian, uint256 confirmations)
+	ExecutedEvent  string // (address indexed account, bytes32 indexed recoveryId, address newOwner)
+	CancelledEvent string // (address indexed account, bytes32 indexed recoveryId)
+}
+
+// loadRecoveryModuleInterface 从 RECOVERY_*_METHOD / RECOVERY_*_EVENT 读取名称，未配置时使用 abi/SocialRecoveryModule.json 中的名称
+func loadRecoveryModuleInterface() recoveryModuleInterface {
+	name := func(key, defaultValue string) string {
+		if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+			return value
+		}
+		return defaultValue
+	}
+	return recoveryModuleInterface{
+		ProposeMethod:  name("RECOVERY_PROPOSE_METHOD", "proposeRecovery"),
+		ConfirmMethod:  name("RECOVERY_CONFIRM_METHOD", "confirmRecovery"),
+		ExecuteMethod:  name("RECOVERY_EXECUTE_METHOD", "executeRecovery"),
+		ProposedEvent:  name("RECOVERY_PROPOSED_EVENT", "RecoveryProposed"),
+		ConfirmedEvent: name("RECOVERY_CONFIRMED_EVENT", "RecoveryConfirmed"),
+		ExecutedEvent:  name("RECOVERY_EXECUTED_EVENT", "RecoveryExecuted"),
+		CancelledEvent: name("RECOVERY_CANCELLED_EVENT", "RecoveryCancelled"),
+	}
+}
+
+// validate 检查 ABI 中的方法参数和事件的 indexed 字段是否与预期布局一致
+func (m recoveryModuleInterface) validate(moduleAbi abi.ABI) error {
+	methods := []struct {
+		name   string
+		inputs []string
+	}{
+		{m.ProposeMethod, []string{"address", "address"}},
+		{m.ConfirmMethod, []string{"address", "bytes32"}},
+		{m.ExecuteMethod, []string{"address", "bytes32"}},
+	}
+	for _, expected := range methods {
+		method, ok := moduleAbi.Methods[expected.name]
+		if !ok {
+			return fmt.Errorf("recovery module ABI has no method %s", expected.name)
+		}
+		if got := argumentTypes(method.Inputs); got != strings.Join(expected.inputs, ",") {
+			return fmt.Errorf("recovery module method %s(%s) does not match expected (%s)", expected.name, got, strings.Join(expected.inputs, ","))
+		}
+	}
+
+	// 所有事件的前两个 indexed 字段必须是 account 和 recoveryId
+	for _, eventName := range []string{m.ProposedEvent, m.ConfirmedEvent, m.ExecutedEvent, m.CancelledEvent} {
+		event, ok := moduleAbi.Events[eventName]
+		if !ok {
+			return fmt.Errorf("recovery module ABI has no event %s", eventName)
+		}
+		var indexed []string
+		for _, input := range event.Inputs {
+			if input.Indexed {
+				indexed = append(indexed, input.Type.String())
+			}
+		}
+		if len(indexed) < 2 || indexed[0] != "address" || indexed[1] != "bytes32" {
+			return fmt.Errorf("recovery module event %s must start with (address indexed account, bytes32 indexed recoveryId)", eventName)
+		}
+	}
+	return nil
+}
+
+// argumentTypes 返回逗号分隔的参数类型
+func argumentTypes(arguments abi.Arguments) string {
+	names := make([]string, len(arguments))
+	for i, argument := range arguments {
+		names[i] = argument.Type.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// RecoveryController 构建社交恢复模块的调用数据，并根据链上事件跟踪恢复状态
+// 已处理到的区块和恢复记录保存在 RECOVERY_STATE_PATH，重启后从上次的位置继续
+type RecoveryController struct {
+	Client        *ethclient.Client
+	ModuleAddress common.Address
+	Nonces        *NonceManager
+	module        recoveryModuleInterface
+	moduleAbi     abi.ABI
+	accountAbi    abi.ABI
+	statePath     string
+	confirmations uint64 // 只处理已有足够确认的区块，与预言机公钥目录使用同一个 ORACLE_INDEX_CONFIRMATIONS
+
+	mu    sync.Mutex
+	state models.RecoveryState
+}
+
+// NewRecoveryController 创建一个新的 RecoveryController 实例，未配置 RECOVERY_MODULE_ADDRESS 时返回 nil
+// executeRecovery 交易由执行者私钥发送，nonces 需要是打包路径使用的同一个 NonceManager
+func NewRecoveryController(nonces *NonceManager) (*RecoveryController, error) {
+	moduleAddress := os.Getenv("RECOVERY_MODULE_ADDRESS")
+	if moduleAddress == "" {
+		return nil, nil
+	}
+	if !common.IsHexAddress(moduleAddress) {
+		return nil, fmt.Errorf("invalid RECOVERY_MODULE_ADDRESS: %q", moduleAddress)
+	}
+
+	rpcURL := os.Getenv("RPC_URL") // 从环境变量中读取 RPC URL
+
+	client, err := ethclient.Dial(rpcURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to the Ethereum client: %w", err)
+	}
+
+	moduleAbi, err := loadABI(os.Getenv("RecoveryModule_ABI"))
+	if err != nil {
+		return nil, err
+	}
+	module := loadRecoveryModuleInterface()
+	if err := module.validate(moduleAbi); err != nil {
+		return nil, err
+	}
+	accountAbi, err := loadABI(os.Getenv("SimpleAccount_ABI"))
+	if err != nil {
+		return nil, err
+	}
+
+	statePath := os.Getenv("RECOVERY_STATE_PATH")
+	if statePath == "" {
+		statePath = defaultRecoveryStatePath
+	}
+
+	confirmations, err := indexConfirmationsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl := &RecoveryController{
+		Client:        client,
+		ModuleAddress: common.HexToAddress(moduleAddress),
+		Nonces:        nonces,
+		module:        module,
+		moduleAbi:     moduleAbi,
+		accountAbi:    accountAbi,
+		statePath:     statePath,
+		confirmations: confirmations,
+		state:         models.RecoveryState{Recoveries: make(map[string]*models.Recovery)},
+	}
+	if err := ctrl.load(); err != nil {
+		return nil, err
+	}
+
+	// 没有历史状态时必须配置起始区块（通常是恢复模块的部署区块），避免从创世区块开始扫描
+	if ctrl.state.NextBlock == 0 {
+		value := os.Getenv("RECOVERY_START_BLOCK")
+		if value == "" {
+			return nil, fmt.Errorf("RECOVERY_START_BLOCK is required when %s does not exist", statePath)
+		}
+		ctrl.state.NextBlock, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECOVERY_START_BLOCK: %v", err)
+		}
+	}
+	return ctrl, nil
+}
+
+// BuildPropose 构建守护者发起恢复的调用
+func (ctrl *RecoveryController) BuildPropose(guardian, account, newOwner common.Address) (*models.RecoveryCall, error) {
+	return ctrl.buildCall(guardian, ctrl.module.ProposeMethod, account, newOwner)
+}
+
+// BuildConfirm 构建守护者确认恢复的调用
+func (ctrl *RecoveryController) BuildConfirm(guardian, account common.Address, recoveryID common.Hash) (*models.RecoveryCall, error) {
+	return ctrl.buildCall(guardian, ctrl.module.ConfirmMethod, account, recoveryID)
+}
+
+// ExecuteRecovery 确认数达到门限后，由执行者账户直接发送 executeRecovery 交易
+func (ctrl *RecoveryController) ExecuteRecovery(account common.Address, recoveryID common.Hash) (string, error) {
+
+	// 使用启动时加载的执行者签名器
+	signer, err := currentSigner()
+	if err != nil {
+		return "", err
+	}
+	fromAddress := signer.Address()
+
+	data, err := ctrl.moduleAbi.Pack(ctrl.module.ExecuteMethod, account, recoveryID)
+	if err != nil {
+		return "", fmt.Errorf("error packing data: %v", err)
+	}
+
+	// 先模拟执行，门限不足等情况直接返回错误，不浪费 gas
+	estimatedGas, err := ctrl.Client.EstimateGas(context.Background(), ethereum.CallMsg{From: fromAddress, To: &ctrl.ModuleAddress, Data: data})
+	if err != nil {
+		reverted := withRevertReason(ctrl.moduleAbi, "executeRecovery would revert", err)
+		if revertData(err) != nil {
+			return "", NewBundlerError(ErrCodeRejected, "%v", reverted)
+		}
+		return "", reverted
+	}
+
+	// 获取建议的 gas price
+	gasPrice, err := ctrl.Client.SuggestGasPrice(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("error getting gas price: %v", err)
+	}
+
+	// 获取区块链的 chain ID
+	chainID, err := ctrl.Client.NetworkID(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("error getting network ID: %v", err)
+	}
+
+	nonce, err := ctrl.Nonces.Next(fromAddress)
+	if err != nil {
+		return "", err
+	}
+
+	// 在估算结果上留出余量，恢复逻辑的 gas 消耗取决于账户和守护者数量，不能使用固定值
+	gasLimit := estimatedGas * (100 + recoveryGasLimitMargin) / 100
+	tx := types.NewTransaction(nonce, ctrl.ModuleAddress, big.NewInt(0), gasLimit, gasPrice, data)
+
+	// 签署交易
+	signedTx, err := signer.SignTx(tx, chainID)
+	if err != nil {
+		ctrl.Nonces.Release(fromAddress, nonce)
+		return "", fmt.Errorf("error signing transaction: %v", err)
+	}
+
+	// 发送交易到区块链
+	if err := ctrl.Client.SendTransaction(context.Background(), signedTx); err != nil {
+		ctrl.Nonces.Release(fromAddress, nonce)
+		return "", fmt.Errorf("error sending transaction: %v", err)
+	}
+	ctrl.Nonces.Sent(fromAddress, nonce)
+
+	fmt.Printf("ExecuteRecovery transaction sent with hash: %s\n", signedTx.Hash().Hex())
+	return signedTx.Hash().Hex(), nil
+}
+
+// GetRecoveries 返回账户的所有恢复记录
+func (ctrl *RecoveryController) GetRecoveries(account common.Address) []models.Recovery {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	var recoveries []models.Recovery
+	for _, recovery := range ctrl.state.Recoveries {
+		if strings.EqualFold(recovery.Account, account.Hex()) {
+			recoveries = append(recoveries, *recovery)
+		}
+	}
+	return recoveries
+}
+
+// Run 定期拉取恢复模块的事件并更新恢复状态，阻塞运行
+func (ctrl *RecoveryController) Run() {
+	interval := defaultRecoveryPollInterval
+	if seconds, err := strconv.Atoi(os.Getenv("RECOVERY_POLL_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := ctrl.syncEvents(); err != nil {
+			log.Printf("Failed to sync recovery events: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// syncEvents 从上次处理到的区块开始分段查询已确认区块的事件，每段处理完后保存状态
+func (ctrl *RecoveryController) syncEvents() error {
+	head, err := ctrl.Client.BlockNumber(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting block number: %v", err)
+	}
+	// 未确认的区块可能被重组回滚，处理后 NextBlock 已经越过，无法再修正
+	if head < ctrl.confirmations {
+		return nil
+	}
+	latest := head - ctrl.confirmations
+
+	for {
+		ctrl.mu.Lock()
+		fromBlock := ctrl.state.NextBlock
+		ctrl.mu.Unlock()
+		if fromBlock > latest {
+			return nil
+		}
+		toBlock := fromBlock + recoveryLogBlockRange - 1
+		if toBlock > latest {
+			toBlock = latest
+		}
+
+		logs, err := ctrl.Client.FilterLogs(context.Background(), ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(fromBlock),
+			ToBlock:   new(big.Int).SetUint64(toBlock),
+			Addresses: []common.Address{ctrl.ModuleAddress},
+		})
+		if err != nil {
+			return fmt.Errorf("error filtering logs: %v", err)
+		}
+
+		for _, vLog := range logs {
+			if err := ctrl.applyEvent(vLog); err != nil {
+				log.Printf("Failed to apply recovery event in tx %s: %v", vLog.TxHash.Hex(), err)
+			}
+		}
+
+		ctrl.mu.Lock()
+		ctrl.state.NextBlock = toBlock + 1
+		ctrl.mu.Unlock()
+		if err := ctrl.save(); err != nil {
+			return err
+		}
+	}
+}
+
+// applyEvent 根据单条事件更新恢复状态
+func (ctrl *RecoveryController) applyEvent(vLog types.Log) error {
+	if len(vLog.Topics) < 3 {
+		return nil
+	}
+	event, err := ctrl.moduleAbi.EventByID(vLog.Topics[0])
+	if err != nil {
+		return nil // 不关心的事件
+	}
+
+	account := common.BytesToAddress(vLog.Topics[1].Bytes())
+	recoveryID := vLog.Topics[2]
+
+	fields := make(map[string]interface{})
+	if len(vLog.Data) > 0 {
+		if err := ctrl.moduleAbi.UnpackIntoMap(fields, event.Name, vLog.Data); err != nil {
+			return fmt.Errorf("error unpacking %s: %v", event.Name, err)
+		}
+	}
+
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	recovery, ok := ctrl.state.Recoveries[recoveryID.Hex()]
+	if !ok {
+		recovery = &models.Recovery{
+			RecoveryID: recoveryID.Hex(),
+			Account:    account.Hex(),
+			Status:     "pending",
+		}
+		ctrl.state.Recoveries[recoveryID.Hex()] = recovery
+	}
+	recovery.UpdatedBlock = vLog.BlockNumber
+
+	switch event.Name {
+	case ctrl.module.ProposedEvent:
+		if len(vLog.Topics) > 3 {
+			recovery.Guardians = appendGuardian(recovery.Guardians, common.BytesToAddress(vLog.Topics[3].Bytes()))
+		}
+		if newOwner, ok := fields["newOwner"].(common.Address); ok {
+			recovery.NewOwner = newOwner.Hex()
+		}
+		recovery.Confirmations = 1
+		recovery.ProposedTx = vLog.TxHash.Hex()
+	case ctrl.module.ConfirmedEvent:
+		if len(vLog.Topics) > 3 {
+			recovery.Guardians = appendGuardian(recovery.Guardians, common.BytesToAddress(vLog.Topics[3].Bytes()))
+		}
+		if confirmations, ok := fields["confirmations"].(*big.Int); ok {
+			recovery.Confirmations = confirmations.Uint64()
+		}
+	case ctrl.module.ExecutedEvent:
+		if newOwner, ok := fields["newOwner"].(common.Address); ok {
+			recovery.NewOwner = newOwner.Hex()
+		}
+		recovery.Status = "executed"
+		recovery.ExecutedTx = vLog.TxHash.Hex()
+	case ctrl.module.CancelledEvent:
+		recovery.Status = "cancelled"
+	}
+	return nil
+}
+
+// appendGuardian 记录确认过的守护者，状态保存前中断时同一段区块会被重新处理，已记录的守护者不再重复添加
+func appendGuardian(guardians []string, guardian common.Address) []string {
+	for _, existing := range guardians {
+		if strings.EqualFold(existing, guardian.Hex()) {
+			return guardians
+		}
+	}
+	return append(guardians, guardian.Hex())
+}
+
+// buildCall 打包恢复模块调用，同时给出守护者为智能账户时的 execute calldata
+func (ctrl *RecoveryController) buildCall(guardian common.Address, method string, args ...interface{}) (*models.RecoveryCall, error) {
+	data, err := ctrl.moduleAbi.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error packing data: %v", err)
+	}
+
+	userOpCallData, err := ctrl.accountAbi.Pack("execute", ctrl.ModuleAddress, big.NewInt(0), data)
+	if err != nil {
+		return nil, fmt.Errorf("error packing execute: %v", err)
+	}
+
+	return &models.RecoveryCall{
+		Guardian:       guardian.Hex(),
+		To:             ctrl.ModuleAddress.Hex(),
+		Data:           hexutil.Encode(data),
+		UserOpCallData: hexutil.Encode(userOpCallData),
+	}, nil
+}
+
+// load 读取状态文件，文件不存在时从空状态开始
+func (ctrl *RecoveryController) load() error {
+	data, err := os.ReadFile(ctrl.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading recovery state: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &ctrl.state); err != nil {
+		return fmt.Errorf("error parsing recovery state: %v", err)
+	}
+	if ctrl.state.Recoveries == nil {
+		ctrl.state.Recoveries = make(map[string]*models.Recovery)
+	}
+	return nil
+}
+
+// save 将状态写入文件，先写临时文件再重命名，避免写一半被中断
+func (ctrl *RecoveryController) save() error {
+	ctrl.mu.Lock()
+	data, err := json.MarshalIndent(ctrl.state, "", "  ")
+	ctrl.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding recovery state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ctrl.statePath), 0o755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	tmpPath := ctrl.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing recovery state: %v", err)
+	}
+	if err := os.Rename(tmpPath, ctrl.statePath); err != nil {
+		return fmt.Errorf("error replacing recovery state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// revertDecoder.go
+
+package controllers
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// maxRevertDepth 嵌套 revert 数据的最大解码层数
+const maxRevertDepth = 8
+
+// 标准 Error(string) 与 Panic(uint256)，所有合约共用
+var (
+	revertErrorString = abi.NewError("Error", abi.Arguments{{Name: "reason", Type: mustNewType("string")}})
+	revertPanic       = abi.NewError("Panic", abi.Arguments{{Name: "code", Type: mustNewType("uint256")}})
+)
+
+// panicReasons Solidity Panic(uint256) 错误码说明
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to zero-initialized function",
+}
+
+// DecodeRevert 使用合约 ABI 中的自定义错误以及 Error(string) / Panic(uint256) 解码 revert 数据
+// bytes 类型的参数如果本身也是 revert 数据，会继续递归解码并放入 Inner
+func DecodeRevert(contractAbi abi.ABI, data []byte) *models.RevertReason {
+	return decodeRevert(contractAbi, data, 0)
+}
+
+// decodeRevert 递归解码，depth 为当前层数
+func decodeRevert(contractAbi abi.ABI, data []byte, depth int) *models.RevertReason {
+	reason := &models.RevertReason{Raw: hexutil.Encode(data)}
+	if len(data) < 4 {
+		reason.Message = "execution reverted without reason"
+		return reason
+	}
+	reason.Selector = hexutil.Encode(data[:4])
+
+	abiError := findRevertError(contractAbi, data[:4])
+	if abiError == nil {
+		reason.Message = fmt.Sprintf("unknown error %s", reason.Selector)
+		return reason
+	}
+	values, err := abiError.Unpack(data)
+	if err != nil {
+		reason.Message = fmt.Sprintf("malformed %s: %v", abiError.Name, err)
+		return reason
+	}
+	reason.Name = abiError.Name
+
+	args := values.([]interface{})
+	var parts []string
+	for i, input := range abiError.Inputs {
+		value := args[i]
+		if raw, ok := value.([]byte); ok {
+			// 内层 revert 数据，例如 FailedOpWithRevert 的 inner、PostOpReverted 的 returnData
+			if reason.Inner == nil && depth < maxRevertDepth && len(raw) >= 4 && findRevertError(contractAbi, raw[:4]) != nil {
+				reason.Inner = decodeRevert(contractAbi, raw, depth+1)
+			}
+			value = hexutil.Encode(raw)
+		}
+		reason.Args = append(reason.Args, models.RevertArg{Name: input.Name, Type: input.Type.String(), Value: value})
+		parts = append(parts, fmt.Sprintf("%s=%v", input.Name, value))
+	}
+
+	switch abiError.Name {
+	case "Error":
+		reason.Message = args[0].(string)
+	case "Panic":
+		code := args[0].(*big.Int)
+		description, ok := panicReasons[code.Uint64()]
+		if !code.IsUint64() || !ok {
+			description = "unknown panic"
+		}
+		reason.Message = fmt.Sprintf("panic 0x%x: %s", code, description)
+	case "FailedOp", "FailedOpWithRevert":
+		reason.Message = fmt.Sprintf("%s (op %v)", args[1].(string), args[0])
+	default:
+		reason.Message = fmt.Sprintf("%s(%s)", abiError.Name, strings.Join(parts, ", "))
+	}
+	if reason.Inner != nil {
+		reason.Message += ": " + reason.Inner.Message
+	}
+	return reason
+}
+
+// findRevertError 按选择器查找错误定义，合约 ABI 优先
+func findRevertError(contractAbi abi.ABI, selector []byte) *abi.Error {
+	for _, abiError := range contractAbi.Errors {
+		if string(abiError.ID[:4]) == string(selector) {
+			abiError := abiError
+			return &abiError
+		}
+	}
+	for _, abiError := range []abi.Error{revertErrorString, revertPanic} {
+		if string(abiError.ID[:4]) == string(selector) {
+			abiError := abiError
+			return &abiError
+		}
+	}
+	return nil
+}
+
+// withRevertReason 如果节点返回了 revert 数据，将解码后的原因附加到错误信息中
+func withRevertReason(contractAbi abi.ABI, prefix string, err error) error {
+	data := revertData(err)
+	if data == nil {
+		return fmt.Errorf("%s: %v", prefix, err)
+	}
+	return fmt.Errorf("%s: %v: %s", prefix, err, DecodeRevert(contractAbi, data).Message)
+}
+
+// mustNewType 创建内置 ABI 类型，仅用于包初始化
+func mustNewType(name string) abi.Type {
+	t, err := abi.NewType(name, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
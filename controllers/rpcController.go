@@ -0,0 +1,110 @@
+// rpcController.go
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+)
+
+// RPCHandler 处理一个 JSON-RPC 方法，返回的错误会通过 AsBundlerError 转换为错误码
+type RPCHandler func(params []json.RawMessage) (interface{}, error)
+
+// RPCController ERC-4337 bundler 的 JSON-RPC 接口，各功能模块通过 Register 注册方法
+type RPCController struct {
+	handlers map[string]RPCHandler
+}
+
+// NewRPCController 创建 JSON-RPC 控制器并注册 eth_ 命名空间的基础方法
+func NewRPCController(userOps *UserOpController) *RPCController {
+	ctrl := &RPCController{handlers: make(map[string]RPCHandler)}
+
+	ctrl.Register("eth_chainId", func(params []json.RawMessage) (interface{}, error) {
+		chainID, err := userOps.Client.ChainID(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("error getting chain ID: %v", err)
+		}
+		return (*hexutil.Big)(chainID), nil
+	})
+
+	ctrl.Register("eth_supportedEntryPoints", func(params []json.RawMessage) (interface{}, error) {
+		return []string{common.HexToAddress(entryPointAddress).Hex()}, nil
+	})
+
+	ctrl.Register("eth_sendUserOperation", func(params []json.RawMessage) (interface{}, error) {
+		if len(params) != 2 {
+			return nil, NewBundlerError(ErrCodeInvalidParams, "expected params [userOp, entryPoint]")
+		}
+		var userOp models.PackedUserOperation
+		if err := json.Unmarshal(params[0], &userOp); err != nil {
+			return nil, invalidParams(err)
+		}
+		if err := checkEntryPoint(params[1]); err != nil {
+			return nil, err
+		}
+
+		// 按规范返回 userOpHash，而不是 bundle 的交易哈希
+		userOpHash, err := userOps.GetUserOpHash(userOp)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := userOps.SubmitUserOp(userOp); err != nil {
+			return nil, err
+		}
+		return userOpHash, nil
+	})
+
+	return ctrl
+}
+
+// Register 注册一个 JSON-RPC 方法
+func (ctrl *RPCController) Register(method string, handler RPCHandler) {
+	ctrl.handlers[method] = handler
+}
+
+// Handle 处理 JSON-RPC 请求，错误以 ERC-4337 错误码返回，HTTP 状态码始终为 200
+func (ctrl *RPCController) Handle(c *gin.Context) {
+	var request models.RPCRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusOK, rpcErrorResponse(nil, NewBundlerError(ErrCodeInvalidRequest, "invalid JSON-RPC request: %v", err)))
+		return
+	}
+
+	handler, ok := ctrl.handlers[request.Method]
+	if !ok {
+		c.JSON(http.StatusOK, rpcErrorResponse(request.ID, NewBundlerError(ErrCodeMethodNotFound, "method %s not found", request.Method)))
+		return
+	}
+
+	result, err := handler(request.Params)
+	if err != nil {
+		c.JSON(http.StatusOK, rpcErrorResponse(request.ID, AsBundlerError(err)))
+		return
+	}
+	c.JSON(http.StatusOK, models.RPCResponse{JSONRPC: "2.0", ID: request.ID, Result: result})
+}
+
+// rpcErrorResponse 构造 JSON-RPC 错误响应
+func rpcErrorResponse(id json.RawMessage, err *BundlerError) models.RPCResponse {
+	return models.RPCResponse{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// checkEntryPoint 确认请求中的 EntryPoint 是 bundler 支持的地址
+func checkEntryPoint(param json.RawMessage) error {
+	var entryPoint string
+	if err := json.Unmarshal(param, &entryPoint); err != nil || !common.IsHexAddress(entryPoint) {
+		return NewBundlerError(ErrCodeInvalidParams, "invalid entryPoint address")
+	}
+	if common.HexToAddress(entryPoint) != common.HexToAddress(entryPointAddress) {
+		return NewBundlerError(ErrCodeInvalidParams, "unsupported entryPoint %s", entryPoint)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// rsaKeyValidation.go
+
+package controllers
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	defaultMinModulusBits = 1024
+	maxModulusBits        = 4096
+)
+
+// ValidateRSAKey 检查模数和指数能否组成一个可用于 DKIM 验签的 RSA 公钥
+// 模数位数下限由 RSA_MIN_MODULUS_BITS 配置，默认 1024
+func ValidateRSAKey(modulus, exponent []byte) error {
+	if len(modulus) == 0 {
+		return fmt.Errorf("modulus is empty")
+	}
+	if len(exponent) == 0 {
+		return fmt.Errorf("exponent is empty")
+	}
+	if modulus[0] == 0 {
+		return fmt.Errorf("modulus has leading zero bytes, strip them before submitting")
+	}
+
+	minBits := defaultMinModulusBits
+	if bits, err := strconv.Atoi(os.Getenv("RSA_MIN_MODULUS_BITS")); err == nil && bits > 0 {
+		minBits = bits
+	}
+
+	n := new(big.Int).SetBytes(modulus)
+	if n.BitLen() < minBits {
+		return fmt.Errorf("modulus is %d bits, at least %d bits required", n.BitLen(), minBits)
+	}
+	if n.BitLen() > maxModulusBits {
+		return fmt.Errorf("modulus is %d bits, at most %d bits supported", n.BitLen(), maxModulusBits)
+	}
+	if n.Bit(0) == 0 {
+		return fmt.Errorf("modulus must be odd")
+	}
+
+	// 指数必须是大于 1 的奇数，且能放进 32 位，常见取值为 65537
+	e := new(big.Int).SetBytes(exponent)
+	if e.BitLen() > 32 {
+		return fmt.Errorf("exponent is too large")
+	}
+	if e.Cmp(big.NewInt(3)) < 0 || e.Bit(0) == 0 {
+		return fmt.Errorf("exponent must be an odd number of at least 3")
+	}
+	if e.Cmp(n) >= 0 {
+		return fmt.Errorf("exponent must be smaller than modulus")
+	}
+	return nil
+}
+
+// ResolveRSAKey 取出条目中的公钥（publicKey 优先于 modulus / exponent）并校验，单条和批量写入共用
+func ResolveRSAKey(entry models.PublicKeyEntry) ([]byte, []byte, error) {
+	modulus, exponent := entry.Modulus, entry.Exponent
+	if entry.PublicKey != "" {
+		var err error
+		modulus, exponent, err = ParseRSAPublicKey(entry.PublicKey)
+		if err != nil {
+			return nil, nil, NewBundlerError(ErrCodeInvalidParams, "%v", err)
+		}
+	}
+	if err := ValidateRSAKey(modulus, exponent); err != nil {
+		return nil, nil, NewBundlerError(ErrCodeInvalidParams, "invalid RSA key: %v", err)
+	}
+	return modulus, exponent, nil
+}
+
+// ParseRSAPublicKey 解析 PEM、base64 DER 或十六进制 DER 格式的 RSA 公钥，返回模数和指数
+func ParseRSAPublicKey(input string) ([]byte, []byte, error) {
+	input = strings.TrimSpace(input)
+
+	var der []byte
+	if block, _ := pem.Decode([]byte(input)); block != nil {
+		der = block.Bytes
+	} else if strings.HasPrefix(input, "0x") || strings.HasPrefix(input, "0X") {
+		decoded, err := hexutil.Decode(input)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid hex public key: %v", err)
+		}
+		der = decoded
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(input), ""))
+		if err != nil {
+			return nil, nil, fmt.Errorf("public key is neither PEM, hex nor base64: %v", err)
+		}
+		der = decoded
+	}
+
+	publicKey, err := parseRSAPublicKeyDER(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return publicKey.N.Bytes(), big.NewInt(int64(publicKey.E)).Bytes(), nil
+}
+
+// parseRSAPublicKeyDER 解析 DER 编码的 RSA 公钥，支持 SubjectPublicKeyInfo 和 PKCS#1 两种编码
+func parseRSAPublicKeyDER(der []byte) (*rsa.PublicKey, error) {
+	if key, err := x509.ParsePKIXPublicKey(der); err == nil {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key is not an RSA public key")
+		}
+		return rsaKey, nil
+	}
+
+	key, err := x509.ParsePKCS1PublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RSA public key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,210 @@
+// signer.go
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"bundler/config"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Signer 交易签名接口，发送交易的路径只依赖该接口，不直接接触私钥
+type Signer interface {
+	// Address 返回签名账户地址
+	Address() common.Address
+	// SignTx 使用 EIP-155 规则为交易签名
+	SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+var (
+	signerMu       sync.RWMutex
+	executorSigner Signer
+)
+
+// LoadSigner 根据 SIGNER_TYPE 创建执行者签名器：
+// local（默认，明文 PRIVATE_KEY）、keystore（KEYSTORE_PATH）、remote（REMOTE_SIGNER_URL + REMOTE_SIGNER_ADDRESS）
+func LoadSigner() error {
+	var signer Signer
+
+	switch signerType := os.Getenv("SIGNER_TYPE"); signerType {
+	case "", "local", "keystore":
+		if signerType == "keystore" && os.Getenv("KEYSTORE_PATH") == "" {
+			return errors.New("SIGNER_TYPE is keystore but KEYSTORE_PATH is not set")
+		}
+		if err := config.LoadExecutorKey(); err != nil {
+			return err
+		}
+		key, err := config.ExecutorKey()
+		if err != nil {
+			return err
+		}
+		signer = NewLocalSigner(key)
+	case "remote":
+		remote, err := NewRemoteSigner(os.Getenv("REMOTE_SIGNER_URL"), os.Getenv("REMOTE_SIGNER_ADDRESS"))
+		if err != nil {
+			return err
+		}
+		signer = remote
+	default:
+		return fmt.Errorf("unsupported SIGNER_TYPE %q", signerType)
+	}
+
+	signerMu.Lock()
+	executorSigner = signer
+	signerMu.Unlock()
+	return nil
+}
+
+// currentSigner 返回启动时加载的执行者签名器
+func currentSigner() (Signer, error) {
+	signerMu.RLock()
+	defer signerMu.RUnlock()
+
+	if executorSigner == nil {
+		return nil, errors.New("executor signer not loaded")
+	}
+	return executorSigner, nil
+}
+
+// LocalSigner 使用内存中的私钥签名，明文私钥和解密后的 keystore 都使用它
+type LocalSigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewLocalSigner 创建一个新的 LocalSigner 实例
+func NewLocalSigner(key *ecdsa.PrivateKey) *LocalSigner {
+	return &LocalSigner{
+		key:     key,
+		address: crypto.PubkeyToAddress(key.PublicKey),
+	}
+}
+
+// Address 返回签名账户地址
+func (s *LocalSigner) Address() common.Address {
+	return s.address
+}
+
+// SignTx 使用本地私钥签名
+func (s *LocalSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.NewEIP155Signer(chainID), s.key)
+}
+
+// RemoteSigner 通过 Clef / web3signer 风格的 eth_signTransaction JSON-RPC 签名，私钥不进入 bundler 进程
+type RemoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+}
+
+// NewRemoteSigner 创建一个新的 RemoteSigner 实例
+func NewRemoteSigner(url, address string) (*RemoteSigner, error) {
+	if url == "" {
+		return nil, errors.New("REMOTE_SIGNER_URL is not set")
+	}
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid REMOTE_SIGNER_ADDRESS: %q", address)
+	}
+
+	client, err := rpc.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to remote signer: %v", err)
+	}
+	return &RemoteSigner{
+		client:  client,
+		address: common.HexToAddress(address),
+	}, nil
+}
+
+// Address 返回签名账户地址
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// signTransactionArgs eth_signTransaction 的参数
+type signTransactionArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to,omitempty"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Data     hexutil.Bytes   `json:"data"`
+	ChainID  *hexutil.Big    `json:"chainId"`
+}
+
+// SignTx 调用远程签名服务的 eth_signTransaction，并校验返回交易的签名者
+func (s *RemoteSigner) SignTx(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := signTransactionArgs{
+		From:     s.address,
+		To:       tx.To(),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Value:    (*hexutil.Big)(tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     tx.Data(),
+		ChainID:  (*hexutil.Big)(chainID),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var result json.RawMessage
+	if err := s.client.CallContext(ctx, &result, "eth_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("remote signer: %v", err)
+	}
+
+	raw, err := parseSignTransactionResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	signedTx := new(types.Transaction)
+	if err := signedTx.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("error decoding signed transaction: %v", err)
+	}
+
+	// 防止远程签名服务篡改交易或使用了错误的账户
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), signedTx)
+	if err != nil {
+		return nil, fmt.Errorf("error recovering signer: %v", err)
+	}
+	if sender != s.address {
+		return nil, fmt.Errorf("remote signer signed with %s, expected %s", sender.Hex(), s.address.Hex())
+	}
+	if signedTx.Nonce() != tx.Nonce() || signedTx.Gas() != tx.Gas() || signedTx.Value().Cmp(tx.Value()) != 0 ||
+		signedTx.To() == nil || tx.To() == nil || *signedTx.To() != *tx.To() || !bytes.Equal(signedTx.Data(), tx.Data()) {
+		return nil, errors.New("remote signer returned a different transaction")
+	}
+	return signedTx, nil
+}
+
+// parseSignTransactionResult 兼容两种返回格式：web3signer 直接返回 raw 十六进制，Clef 返回 {"raw": ..., "tx": ...}
+func parseSignTransactionResult(result json.RawMessage) ([]byte, error) {
+	var rawHex hexutil.Bytes
+	if err := json.Unmarshal(result, &rawHex); err == nil {
+		return rawHex, nil
+	}
+
+	var clefResult struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := json.Unmarshal(result, &clefResult); err != nil || len(clefResult.Raw) == 0 {
+		return nil, fmt.Errorf("unexpected eth_signTransaction result: %s", string(result))
+	}
+	return clefResult.Raw, nil
+}
@@ -0,0 +1,198 @@
+// signer_test.go
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// mockSignerServer 模拟 eth_signTransaction 签名服务，respond 根据请求参数决定返回的 result 或 error
+func mockSignerServer(t *testing.T, respond func(args signTransactionArgs) (interface{}, *rpcTestError)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("error decoding request: %v", err)
+			return
+		}
+
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+		var args signTransactionArgs
+		if request.Method != "eth_signTransaction" || len(request.Params) != 1 {
+			response["error"] = rpcTestError{Code: -32601, Message: "method not found"}
+		} else if err := json.Unmarshal(request.Params[0], &args); err != nil {
+			response["error"] = rpcTestError{Code: -32602, Message: err.Error()}
+		} else if result, rpcErr := respond(args); rpcErr != nil {
+			response["error"] = rpcErr
+		} else {
+			response["result"] = result
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// rpcTestError JSON-RPC 错误对象
+type rpcTestError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// signArgs 按请求参数构建交易并用 key 签名，返回 RLP 编码
+func signArgs(t *testing.T, key *ecdsa.PrivateKey, args signTransactionArgs) hexutil.Bytes {
+	t.Helper()
+	tx := types.NewTransaction(uint64(args.Nonce), *args.To, args.Value.ToInt(), uint64(args.Gas), args.GasPrice.ToInt(), args.Data)
+	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(args.ChainID.ToInt()), key)
+	if err != nil {
+		t.Fatalf("error signing transaction: %v", err)
+	}
+	raw, err := signedTx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("error encoding transaction: %v", err)
+	}
+	return raw
+}
+
+func newTestRemoteSigner(t *testing.T, url string, address common.Address) *RemoteSigner {
+	t.Helper()
+	signer, err := NewRemoteSigner(url, address.Hex())
+	if err != nil {
+		t.Fatalf("NewRemoteSigner returned error: %v", err)
+	}
+	return signer
+}
+
+func testTransaction() *types.Transaction {
+	to := common.HexToAddress("0x1A5C9969F47Ef041c3A359ae4ae9fd9E70eA5653")
+	return types.NewTransaction(7, to, big.NewInt(1000), 200000, big.NewInt(2000000000), []byte{0xde, 0xad, 0xbe, 0xef})
+}
+
+func TestRemoteSignerRoundTrip(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	chainID := big.NewInt(11155111)
+
+	tests := []struct {
+		name   string
+		result func(raw hexutil.Bytes) interface{}
+	}{
+		{"raw hex", func(raw hexutil.Bytes) interface{} { return raw }},
+		{"clef", func(raw hexutil.Bytes) interface{} {
+			return map[string]interface{}{"raw": raw, "tx": map[string]string{}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := mockSignerServer(t, func(args signTransactionArgs) (interface{}, *rpcTestError) {
+				if args.From != address {
+					t.Errorf("from = %s, want %s", args.From.Hex(), address.Hex())
+				}
+				if args.ChainID.ToInt().Cmp(chainID) != 0 {
+					t.Errorf("chainId = %v, want %v", args.ChainID, chainID)
+				}
+				return tt.result(signArgs(t, key, args)), nil
+			})
+
+			tx := testTransaction()
+			signedTx, err := newTestRemoteSigner(t, server.URL, address).SignTx(tx, chainID)
+			if err != nil {
+				t.Fatalf("SignTx returned error: %v", err)
+			}
+			sender, err := types.Sender(types.LatestSignerForChainID(chainID), signedTx)
+			if err != nil || sender != address {
+				t.Fatalf("sender = %s (%v), want %s", sender.Hex(), err, address.Hex())
+			}
+			if signedTx.Nonce() != tx.Nonce() || signedTx.Gas() != tx.Gas() || *signedTx.To() != *tx.To() {
+				t.Errorf("signed transaction does not match the request")
+			}
+		})
+	}
+}
+
+func TestRemoteSignerErrors(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	otherKey, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	chainID := big.NewInt(11155111)
+
+	tests := []struct {
+		name    string
+		respond func(args signTransactionArgs) (interface{}, *rpcTestError)
+		wantErr string
+	}{
+		{
+			"signer rejects",
+			func(args signTransactionArgs) (interface{}, *rpcTestError) {
+				return nil, &rpcTestError{Code: -32000, Message: "request denied"}
+			},
+			"remote signer: request denied",
+		},
+		{
+			"unexpected result",
+			func(args signTransactionArgs) (interface{}, *rpcTestError) {
+				return map[string]string{"signature": "0x00"}, nil
+			},
+			"unexpected eth_signTransaction result",
+		},
+		{
+			"undecodable transaction",
+			func(args signTransactionArgs) (interface{}, *rpcTestError) {
+				return hexutil.Bytes{0x01, 0x02}, nil
+			},
+			"error decoding signed transaction",
+		},
+		{
+			"wrong account",
+			func(args signTransactionArgs) (interface{}, *rpcTestError) {
+				return signArgs(t, otherKey, args), nil
+			},
+			"remote signer signed with",
+		},
+		{
+			"tampered transaction",
+			func(args signTransactionArgs) (interface{}, *rpcTestError) {
+				args.Value = (*hexutil.Big)(big.NewInt(1e18))
+				return signArgs(t, key, args), nil
+			},
+			"remote signer returned a different transaction",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := mockSignerServer(t, tt.respond)
+			_, err := newTestRemoteSigner(t, server.URL, address).SignTx(testTransaction(), chainID)
+			if err == nil {
+				t.Fatalf("SignTx succeeded, want error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRemoteSignerValidatesConfig(t *testing.T) {
+	if _, err := NewRemoteSigner("", "0x1A5C9969F47Ef041c3A359ae4ae9fd9E70eA5653"); err == nil {
+		t.Error("expected error for empty URL")
+	}
+	if _, err := NewRemoteSigner("http://127.0.0.1:8550", "not-an-address"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
@@ -2,9 +2,7 @@ package controllers
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
@@ -14,10 +12,10 @@ import (
 
 	"bundler/models"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gin-gonic/gin"
 )
@@ -27,7 +25,13 @@ const (
 )
 
 type UserOpController struct {
-	Client *ethclient.Client
+	Client             *ethclient.Client
+	Mempool            *Mempool
+	Executors          *ExecutorPool
+	PaymasterServices  *PaymasterServiceClient
+	VerifyingPaymaster *VerifyingPaymaster // 配置了内置 paymaster 时，提交后将赞助预留计入每日花费
+
+	entryPointAbi abi.ABI
 }
 
 // NewUserOpController 创建一个新的 UserOpController 实例
@@ -39,9 +43,35 @@ func NewUserOpController() (*UserOpController, error) {
 		return nil, fmt.Errorf("Failed to connect to the Ethereum client: %w", err)
 	}
 
-	return &UserOpController{
-		Client: client,
-	}, nil
+	entryPointAbi, err := loadABI(os.Getenv("EntryPoint_ABI"))
+	if err != nil {
+		return nil, err
+	}
+	executors, err := NewExecutorPool(client)
+	if err != nil {
+		return nil, err
+	}
+	paymasterServices, err := NewPaymasterServiceClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl := &UserOpController{
+		Client:            client,
+		Mempool:           NewMempool(os.Getenv("MEMPOOL_SNAPSHOT_PATH")),
+		Executors:         executors,
+		PaymasterServices: paymasterServices,
+		entryPointAbi:     entryPointAbi,
+	}
+
+	// 从快照恢复重启前未确认的 bundle 交易
+	if err := ctrl.Mempool.Load(); err != nil {
+		return nil, err
+	}
+	ctrl.recoverInFlight()
+	go ctrl.trackInFlight()
+
+	return ctrl, nil
 }
 
 // StoreUserOp 处理接收到的 UserOp 请求
@@ -50,58 +80,91 @@ func (ctrl *UserOpController) StoreUserOp(c *gin.Context) {
 
 	// 绑定 JSON 请求体到 userOp 结构体
 	if err := c.ShouldBindJSON(&userOp); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		RespondError(c, invalidParams(err))
 		return
 	}
 
 	// 验证并解码每个字段的十六进制字符串
-	initCode, err := hexStringToBytes(userOp.InitCode)
+	initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature, err := decodeUserOp(userOp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid initCode: %v", err)})
+		RespondError(c, err)
 		return
 	}
 
-	callData, err := hexStringToBytes(userOp.CallData)
+	// 处理并发送 UserOp
+	txHash, err := ctrl.processAndSendUserOp(userOp, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid callData: %v", err)})
+		RespondError(c, err)
 		return
 	}
 
-	accountGasLimits, err := hexStringToBytes(userOp.AccountGasLimits)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid accountGasLimits: %v", err)})
-		return
+	c.JSON(http.StatusOK, gin.H{"message": "UserOp received and sent", "transactionHash": txHash})
+}
+
+// ApplyPaymasterService UserOp 没有 paymasterAndData 且指定了 paymaster 服务时，通过 ERC-7677 服务填充
+// 账户签名覆盖 paymasterAndData，所以只能在钱包签名之前调用（/userOp/paymasterData），提交路径不再填充
+func (ctrl *UserOpController) ApplyPaymasterService(userOp models.PackedUserOperation, service *models.PaymasterService) (models.PackedUserOperation, error) {
+	if service == nil || (userOp.PaymasterAndData != "" && userOp.PaymasterAndData != "0x") {
+		return userOp, nil
 	}
 
-	gasFees, err := hexStringToBytes(userOp.GasFees)
+	chainID, err := ctrl.Client.ChainID(context.Background())
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid gasFees: %v", err)})
-		return
+		return userOp, fmt.Errorf("error getting chain ID: %v", err)
 	}
+	return ctrl.PaymasterServices.Fill(userOp, *service, chainID)
+}
 
-	paymasterAndData, err := hexStringToBytes(userOp.PaymasterAndData)
+// SubmitUserOp 解码并提交一个已经构建好的 UserOp，供内部流程复用 StoreUserOp 的发送路径
+func (ctrl *UserOpController) SubmitUserOp(userOp models.PackedUserOperation) (string, error) {
+	initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature, err := decodeUserOp(userOp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid paymasterAndData: %v", err)})
-		return
+		return "", err
 	}
+	return ctrl.processAndSendUserOp(userOp, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature)
+}
 
-	signature, err := hexStringToBytes(userOp.Signature)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid signature: %v", err)})
+// decodeUserOp 验证并解码 UserOp 中各个十六进制字段，格式错误时返回 ErrCodeInvalidParams
+func decodeUserOp(userOp models.PackedUserOperation) (initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature []byte, err error) {
+	defer func() {
+		if err != nil {
+			err = invalidParams(err)
+		}
+	}()
+	if userOp.Nonce == nil {
+		err = errors.New("missing nonce")
 		return
 	}
-
-	// 确保存储和输出数据的一致性
-	userOp.Nonce = big.NewInt(userOp.Nonce.Int64())
-
-	// 处理并发送 UserOp
-	txHash, err := ctrl.processAndSendUserOp(userOp, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if userOp.PreVerificationGas == nil {
+		err = errors.New("missing preVerificationGas")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "UserOp received and sent", "transactionHash": txHash})
+	if initCode, err = hexStringToBytes(userOp.InitCode); err != nil {
+		err = fmt.Errorf("invalid initCode: %v", err)
+		return
+	}
+	if callData, err = hexStringToBytes(userOp.CallData); err != nil {
+		err = fmt.Errorf("invalid callData: %v", err)
+		return
+	}
+	if accountGasLimits, err = hexStringToBytes(userOp.AccountGasLimits); err != nil {
+		err = fmt.Errorf("invalid accountGasLimits: %v", err)
+		return
+	}
+	if gasFees, err = hexStringToBytes(userOp.GasFees); err != nil {
+		err = fmt.Errorf("invalid gasFees: %v", err)
+		return
+	}
+	if paymasterAndData, err = hexStringToBytes(userOp.PaymasterAndData); err != nil {
+		err = fmt.Errorf("invalid paymasterAndData: %v", err)
+		return
+	}
+	if signature, err = hexStringToBytes(userOp.Signature); err != nil {
+		err = fmt.Errorf("invalid signature: %v", err)
+		return
+	}
+	return
 }
 
 // hexStringToBytes 将十六进制字符串转换为字节数组
@@ -126,58 +189,43 @@ func hexStringToBytes(hexStr string) ([]byte, error) {
 
 // processAndSendUserOp 处理并发送 UserOp 到区块链
 func (ctrl *UserOpController) processAndSendUserOp(userOp models.PackedUserOperation, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature []byte) (string, error) {
-	privateKey := os.Getenv("PRIVATE_KEY") // 从环境变量中读取私钥
-	abiPath := os.Getenv("EntryPoint_ABI") // 合约 ABI 文件路径
-
-	// 将私钥字符串转换为 ECDSA 私钥
-	privateKeyECDSA, err := crypto.HexToECDSA(privateKey)
-	if err != nil {
-		return "", fmt.Errorf("error converting private key: %v", err)
+	// 账户尚未部署时确认 Sender 与 initCode 计算出的地址一致
+	if err := ctrl.validateSender(userOp, initCode); err != nil {
+		return "", err
 	}
 
-	publicKey := privateKeyECDSA.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return "", fmt.Errorf("error asserting type of public key")
+	// 拒绝 nonce 已过期或重复的 UserOp
+	if err := ctrl.checkNonce(userOp); err != nil {
+		return "", err
 	}
 
-	// 从公钥推导出以太坊地址
-	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-
-	// 读取并解析 ABI 文件
-	abiData, err := os.ReadFile(abiPath)
+	// 从执行者池中选取一个空闲执行者，交易未成功发出时释放
+	signer, err := ctrl.Executors.Acquire()
 	if err != nil {
-		return "", fmt.Errorf("error reading ABI file: %v", err)
+		return "", err
 	}
-
-	var contractAbi abi.ABI
-	if err := json.Unmarshal(abiData, &contractAbi); err != nil {
-		return "", fmt.Errorf("error parsing ABI: %v", err)
+	fromAddress := signer.Address()
+	sent := false
+	defer func() {
+		if !sent {
+			ctrl.Executors.Release(fromAddress)
+		}
+	}()
+
+	contractAbi := ctrl.entryPointAbi
+
+	// 预留 paymaster 存款，所有在途 UserOp 的最大费用超过存款时拒绝
+	paymaster, maxCost, err := ctrl.reservePaymasterDeposit(contractAbi, userOp)
+	if err != nil {
+		return "", err
+	}
+	if maxCost != nil {
+		defer ctrl.Mempool.ReleasePaymaster(paymaster, maxCost)
 	}
 
 	// 使用 ABI 打包数据以调用 handleOps 方法
-	ops := []struct {
-		Sender             common.Address
-		Nonce              *big.Int
-		InitCode           []byte
-		CallData           []byte
-		AccountGasLimits   [32]byte
-		PreVerificationGas *big.Int
-		GasFees            [32]byte
-		PaymasterAndData   []byte
-		Signature          []byte
-	}{
-		{
-			Sender:             userOp.Sender,
-			Nonce:              userOp.Nonce,
-			InitCode:           initCode,
-			CallData:           callData,
-			AccountGasLimits:   toFixedSizeByteArray(accountGasLimits),
-			PreVerificationGas: userOp.PreVerificationGas,
-			GasFees:            toFixedSizeByteArray(gasFees),
-			PaymasterAndData:   paymasterAndData,
-			Signature:          signature,
-		},
+	ops := []entryPointUserOp{
+		newEntryPointUserOp(userOp, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature),
 	}
 
 	beneficiary := fromAddress // 可以根据需要修改
@@ -187,10 +235,17 @@ func (ctrl *UserOpController) processAndSendUserOp(userOp models.PackedUserOpera
 		return "", fmt.Errorf("error packing data: %v", err)
 	}
 
-	// 获取账户的当前 nonce
-	nonce, err := ctrl.Client.PendingNonceAt(context.Background(), fromAddress)
+	// 发送前模拟 handleOps，被 EntryPoint 拒绝时返回带错误码的错误
+	gasLimit := uint64(10000000) // 增加 gas limit，确保有足够的 gas
+	toAddress := common.HexToAddress(entryPointAddress)
+	_, err = ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{
+		From: fromAddress,
+		To:   &toAddress,
+		Gas:  gasLimit,
+		Data: data,
+	}, nil)
 	if err != nil {
-		return "", fmt.Errorf("error getting nonce: %v", err)
+		return "", handleOpsError(contractAbi, err)
 	}
 
 	// 获取建议的 gas price
@@ -199,10 +254,19 @@ func (ctrl *UserOpController) processAndSendUserOp(userOp models.PackedUserOpera
 		return "", fmt.Errorf("error getting gas price: %v", err)
 	}
 
+	// 获取执行者的下一个 nonce
+	nonce, err := ctrl.Executors.Nonces.Next(fromAddress)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if !sent {
+			ctrl.Executors.Nonces.Release(fromAddress, nonce)
+		}
+	}()
+
 	// 创建交易对象
 	value := big.NewInt(0)
-	gasLimit := uint64(10000000) // 增加 gas limit，确保有足够的 gas
-	toAddress := common.HexToAddress(entryPointAddress)
 	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, data)
 
 	// 获取区块链的 chain ID
@@ -212,7 +276,7 @@ func (ctrl *UserOpController) processAndSendUserOp(userOp models.PackedUserOpera
 	}
 
 	// 签署交易
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKeyECDSA)
+	signedTx, err := signer.SignTx(tx, chainID)
 	if err != nil {
 		return "", fmt.Errorf("error signing transaction: %v", err)
 	}
@@ -220,17 +284,126 @@ func (ctrl *UserOpController) processAndSendUserOp(userOp models.PackedUserOpera
 	// 发送交易到区块链
 	err = ctrl.Client.SendTransaction(context.Background(), signedTx)
 	if err != nil {
-		return "", fmt.Errorf("error sending transaction: %v", err)
+		return "", withRevertReason(contractAbi, "error sending transaction", err)
+	}
+
+	// 记录在途 bundle，直到交易上链后才释放执行者
+	sent = true
+	ctrl.Executors.Nonces.Sent(fromAddress, nonce)
+	ctrl.Executors.MarkInFlight(fromAddress, signedTx.Hash())
+	ctrl.Mempool.Track(signedTx.Hash(), fromAddress, []models.PackedUserOperation{userOp})
+	if ctrl.VerifyingPaymaster != nil {
+		ctrl.VerifyingPaymaster.Commit(userOp)
 	}
+	go ctrl.watchBundle(signedTx.Hash(), fromAddress)
 
 	// 输出交易哈希
 	fmt.Printf("Transaction sent with hash: %s\n", signedTx.Hash().Hex())
 	return signedTx.Hash().Hex(), nil
 }
 
+// entryPointUserOp handleOps / getUserOpHash 参数中 PackedUserOperation 的 ABI 结构
+type entryPointUserOp struct {
+	Sender             common.Address
+	Nonce              *big.Int
+	InitCode           []byte
+	CallData           []byte
+	AccountGasLimits   [32]byte
+	PreVerificationGas *big.Int
+	GasFees            [32]byte
+	PaymasterAndData   []byte
+	Signature          []byte
+}
+
+// newEntryPointUserOp 用解码后的字段构建 ABI 参数
+func newEntryPointUserOp(userOp models.PackedUserOperation, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature []byte) entryPointUserOp {
+	return entryPointUserOp{
+		Sender:             userOp.Sender,
+		Nonce:              userOp.Nonce,
+		InitCode:           initCode,
+		CallData:           callData,
+		AccountGasLimits:   toFixedSizeByteArray(accountGasLimits),
+		PreVerificationGas: userOp.PreVerificationGas,
+		GasFees:            toFixedSizeByteArray(gasFees),
+		PaymasterAndData:   paymasterAndData,
+		Signature:          signature,
+	}
+}
+
+// GetUserOpHash 调用 EntryPoint 的 getUserOpHash，返回 eth_sendUserOperation 需要返回给钱包的 userOpHash
+func (ctrl *UserOpController) GetUserOpHash(userOp models.PackedUserOperation) (common.Hash, error) {
+	initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature, err := decodeUserOp(userOp)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	op := newEntryPointUserOp(userOp, initCode, callData, accountGasLimits, gasFees, paymasterAndData, signature)
+	data, err := ctrl.entryPointAbi.Pack("getUserOpHash", op)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("error packing data: %v", err)
+	}
+
+	toAddress := common.HexToAddress(entryPointAddress)
+	result, err := ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: data}, nil)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("error calling getUserOpHash: %v", err)
+	}
+
+	var userOpHash [32]byte
+	if err := ctrl.entryPointAbi.UnpackIntoInterface(&userOpHash, "getUserOpHash", result); err != nil {
+		return common.Hash{}, fmt.Errorf("error unpacking result: %v", err)
+	}
+	return userOpHash, nil
+}
+
 // toFixedSizeByteArray 将字节切片转换为固定大小的字节数组
 func toFixedSizeByteArray(data []byte) [32]byte {
 	var array [32]byte
 	copy(array[:], data)
 	return array
 }
+
+// GetSenderAddress 调用 EntryPoint 的 getSenderAddress(initCode)，从 SenderAddressResult revert 中取出账户地址
+func (ctrl *UserOpController) GetSenderAddress(initCode []byte) (common.Address, error) {
+	if len(initCode) < common.AddressLength {
+		return common.Address{}, NewBundlerError(ErrCodeInvalidParams, "initCode must start with a factory address")
+	}
+
+	contractAbi := ctrl.entryPointAbi
+	data, err := contractAbi.Pack("getSenderAddress", initCode)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("error packing data: %v", err)
+	}
+
+	// getSenderAddress 总是 revert，正常返回说明 EntryPoint 不符合预期
+	toAddress := common.HexToAddress(entryPointAddress)
+	_, err = ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: data}, nil)
+	if err == nil {
+		return common.Address{}, errors.New("getSenderAddress returned without SenderAddressResult")
+	}
+	revert := revertData(err)
+	if revert == nil {
+		return common.Address{}, fmt.Errorf("error calling getSenderAddress: %v", err)
+	}
+
+	reason := DecodeRevert(contractAbi, revert)
+	if reason.Name != "SenderAddressResult" {
+		return common.Address{}, &BundlerError{Code: ErrCodeRejected, Message: fmt.Sprintf("getSenderAddress failed: %s", reason.Message), Data: reason}
+	}
+	return reason.Args[0].Value.(common.Address), nil
+}
+
+// validateSender 带有 initCode 的 UserOp，其 Sender 必须是 initCode 部署出的地址
+func (ctrl *UserOpController) validateSender(userOp models.PackedUserOperation, initCode []byte) error {
+	if len(initCode) == 0 {
+		return nil
+	}
+	sender, err := ctrl.GetSenderAddress(initCode)
+	if err != nil {
+		return err
+	}
+	if sender != userOp.Sender {
+		return NewBundlerError(ErrCodeRejected, "sender %s does not match initCode sender %s", userOp.Sender.Hex(), sender.Hex())
+	}
+	return nil
+}
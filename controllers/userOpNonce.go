@@ -0,0 +1,46 @@
+// userOpNonce.go
+
+package controllers
+
+import (
+	"math/big"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxNonceKey nonce key 为 192 位
+var maxNonceKey = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 192), big.NewInt(1))
+
+// SplitNonce 拆分 nonce，高 192 位为 key，低 64 位为序号
+func SplitNonce(nonce *big.Int) (*big.Int, uint64) {
+	key := new(big.Int).Rsh(nonce, 64)
+	sequence := new(big.Int).Sub(nonce, new(big.Int).Lsh(key, 64))
+	return key, sequence.Uint64()
+}
+
+// GetNonce 查询账户在指定 key 下的下一个 nonce，返回值包含 key 和序号
+func (ctrl *UserOpController) GetNonce(sender common.Address, key *big.Int) (*big.Int, error) {
+	if key.Sign() < 0 || key.Cmp(maxNonceKey) > 0 {
+		return nil, NewBundlerError(ErrCodeInvalidParams, "nonce key must be a 192-bit unsigned integer")
+	}
+	return ctrl.getNonce(sender, key)
+}
+
+// checkNonce 拒绝 nonce 已被使用或已有相同 nonce 在途的 UserOp
+func (ctrl *UserOpController) checkNonce(userOp models.PackedUserOperation) error {
+	if ctrl.Mempool.HasUserOp(userOp.Sender, userOp.Nonce) {
+		return NewBundlerError(ErrCodeRejected, "UserOp from %s with nonce %v is already pending", userOp.Sender.Hex(), userOp.Nonce)
+	}
+
+	key, sequence := SplitNonce(userOp.Nonce)
+	current, err := ctrl.getNonce(userOp.Sender, key)
+	if err != nil {
+		return err
+	}
+	if _, currentSequence := SplitNonce(current); sequence < currentSequence {
+		return NewBundlerError(ErrCodeRejected, "nonce is stale: sequence %d for key %v, next is %d", sequence, key, currentSequence)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// userOpTracker.go
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	defaultMempoolSnapshotInterval   = 15 * time.Second
+	defaultBundleReceiptPollInterval = 2 * time.Second
+	droppedBundleChecks              = 3 // 连续查不到交易的次数达到该值时视为被丢弃
+)
+
+// recoverInFlight 在启动时逐个检查快照中的 bundle：
+// 已上链的移除，仍在交易池中的继续跟踪，已被丢弃的重新校验后重新提交
+func (ctrl *UserOpController) recoverInFlight() {
+	bundles := ctrl.Mempool.InFlight()
+	if len(bundles) == 0 {
+		return
+	}
+	log.Printf("Recovering %d in-flight bundle(s) from snapshot", len(bundles))
+
+	// 快照中仍在途的执行者先标记为占用
+	for _, bundle := range bundles {
+		ctrl.Executors.MarkInFlight(bundle.Executor, bundle.TxHash)
+	}
+	ctrl.reconcileInFlight(true)
+}
+
+// trackInFlight 定期检查在途 bundle 的上链情况并保存快照
+func (ctrl *UserOpController) trackInFlight() {
+	interval := defaultMempoolSnapshotInterval
+	if seconds, err := strconv.Atoi(os.Getenv("MEMPOOL_SNAPSHOT_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctrl.reconcileInFlight(false)
+		if err := ctrl.Mempool.Save(); err != nil {
+			log.Printf("Failed to save mempool snapshot: %v", err)
+		}
+	}
+}
+
+// reconcileInFlight 根据链上状态更新在途 bundle，resubmitDropped 为 true 时重新提交被丢弃交易中的 UserOp
+func (ctrl *UserOpController) reconcileInFlight(resubmitDropped bool) {
+	for _, bundle := range ctrl.Mempool.InFlight() {
+		receipt, err := ctrl.Client.TransactionReceipt(context.Background(), bundle.TxHash)
+		if err == nil {
+			ctrl.bundleMined(bundle, receipt)
+			continue
+		}
+		if err != ethereum.NotFound {
+			log.Printf("Failed to get receipt for bundle %s: %v", bundle.TxHash.Hex(), err)
+			continue
+		}
+
+		if !resubmitDropped {
+			continue
+		}
+
+		_, isPending, err := ctrl.Client.TransactionByHash(context.Background(), bundle.TxHash)
+		if err == nil && isPending {
+			log.Printf("Bundle %s still pending, resuming tracking", bundle.TxHash.Hex())
+			go ctrl.watchBundle(bundle.TxHash, bundle.Executor)
+			continue
+		}
+		ctrl.bundleDropped(bundle)
+	}
+}
+
+// watchBundle 轮询单个 bundle 的回执，上链后立即释放执行者，不必等待快照周期；
+// 连续多次在节点中查不到交易时视为被丢弃，重新提交其中的 UserOp
+func (ctrl *UserOpController) watchBundle(txHash common.Hash, executor common.Address) {
+	interval := defaultBundleReceiptPollInterval
+	if seconds, err := strconv.Atoi(os.Getenv("BUNDLE_RECEIPT_POLL_INTERVAL")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	misses := 0
+	for range ticker.C {
+		bundle, ok := ctrl.Mempool.Get(txHash)
+		if !ok {
+			// 已由 reconcileInFlight 处理
+			return
+		}
+
+		receipt, err := ctrl.Client.TransactionReceipt(context.Background(), txHash)
+		if err == nil {
+			ctrl.bundleMined(bundle, receipt)
+			return
+		}
+		if err != ethereum.NotFound {
+			log.Printf("Failed to get receipt for bundle %s: %v", txHash.Hex(), err)
+			continue
+		}
+
+		if _, _, err := ctrl.Client.TransactionByHash(context.Background(), txHash); err == ethereum.NotFound {
+			misses++
+		} else {
+			misses = 0
+		}
+		if misses >= droppedBundleChecks {
+			ctrl.bundleDropped(bundle)
+			return
+		}
+	}
+}
+
+// bundleMined bundle 已上链，移出在途列表并释放执行者
+func (ctrl *UserOpController) bundleMined(bundle models.InFlightBundle, receipt *types.Receipt) {
+	if !ctrl.Mempool.Remove(bundle.TxHash) {
+		return
+	}
+	log.Printf("Bundle %s mined in block %v with status %d", bundle.TxHash.Hex(), receipt.BlockNumber, receipt.Status)
+	ctrl.Executors.ReleaseBundle(bundle.Executor, bundle.TxHash)
+}
+
+// bundleDropped 交易已不在节点中，释放执行者并重新校验、提交其中的 UserOp
+func (ctrl *UserOpController) bundleDropped(bundle models.InFlightBundle) {
+	if !ctrl.Mempool.Remove(bundle.TxHash) {
+		return
+	}
+	log.Printf("Bundle %s was dropped, resubmitting its UserOps", bundle.TxHash.Hex())
+	ctrl.Executors.ReleaseBundle(bundle.Executor, bundle.TxHash)
+	ctrl.Executors.Nonces.Reset(bundle.Executor)
+	for _, userOp := range bundle.UserOps {
+		if err := ctrl.revalidateUserOp(userOp); err != nil {
+			log.Printf("Dropping UserOp from %s with nonce %v: %v", userOp.Sender.Hex(), userOp.Nonce, err)
+			continue
+		}
+		txHash, err := ctrl.SubmitUserOp(userOp)
+		if err != nil {
+			log.Printf("Failed to resubmit UserOp from %s with nonce %v: %v", userOp.Sender.Hex(), userOp.Nonce, err)
+			continue
+		}
+		log.Printf("Resubmitted UserOp from %s in transaction %s", userOp.Sender.Hex(), txHash)
+	}
+}
+
+// revalidateUserOp 根据当前链上状态检查 UserOp 是否仍然可以执行
+func (ctrl *UserOpController) revalidateUserOp(userOp models.PackedUserOperation) error {
+	if userOp.Nonce == nil {
+		return fmt.Errorf("missing nonce")
+	}
+
+	key, _ := SplitNonce(userOp.Nonce)
+	current, err := ctrl.getNonce(userOp.Sender, key)
+	if err != nil {
+		return err
+	}
+	if current.Cmp(userOp.Nonce) != 0 {
+		return fmt.Errorf("nonce is stale, current nonce is %v", current)
+	}
+	return nil
+}
+
+// getNonce 调用 EntryPoint 合约的 getNonce 方法，获取 sender 在指定 key 下的 nonce
+func (ctrl *UserOpController) getNonce(sender common.Address, key *big.Int) (*big.Int, error) {
+	data, err := ctrl.entryPointAbi.Pack("getNonce", sender, key)
+	if err != nil {
+		return nil, fmt.Errorf("error packing data: %v", err)
+	}
+
+	toAddress := common.HexToAddress(entryPointAddress)
+	result, err := ctrl.Client.CallContract(context.Background(), ethereum.CallMsg{To: &toAddress, Data: data}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error calling getNonce: %v", err)
+	}
+
+	var nonce *big.Int
+	if err := ctrl.entryPointAbi.UnpackIntoInterface(&nonce, "getNonce", result); err != nil {
+		return nil, fmt.Errorf("error unpacking result: %v", err)
+	}
+	return nonce, nil
+}
@@ -0,0 +1,393 @@
+// verifyingPaymaster.go
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bundler/config"
+	"bundler/models"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	defaultPaymasterValidity             = 10 * time.Minute
+	defaultPaymasterVerificationGasLimit = 100000
+	paymasterClockSkew                   = 10 * time.Second // validAfter 向前留出的余量，避免节点时钟稍慢时报 AA32
+)
+
+// sponsorshipKey 一次赞助对应的 UserOp，同一个 sender 和 nonce 重复申请时替换之前的预留
+type sponsorshipKey struct {
+	sender common.Address
+	nonce  string
+}
+
+// sponsorship 已签发但 UserOp 尚未提交的赞助，过期未提交时退回额度
+type sponsorship struct {
+	amount    *big.Int
+	expiresAt time.Time
+}
+
+// paymasterHashArgs VerifyingPaymaster.getHash 中 abi.encode 的参数
+var paymasterHashArgs = abi.Arguments{
+	{Type: mustNewType("address")}, // sender
+	{Type: mustNewType("uint256")}, // nonce
+	{Type: mustNewType("bytes32")}, // keccak256(initCode)
+	{Type: mustNewType("bytes32")}, // keccak256(callData)
+	{Type: mustNewType("bytes32")}, // accountGasLimits
+	{Type: mustNewType("uint256")}, // paymaster 验证与 postOp gas limit
+	{Type: mustNewType("uint256")}, // preVerificationGas
+	{Type: mustNewType("bytes32")}, // gasFees
+	{Type: mustNewType("uint256")}, // chainId
+	{Type: mustNewType("address")}, // paymaster
+	{Type: mustNewType("uint48")},  // validUntil
+	{Type: mustNewType("uint48")},  // validAfter
+}
+
+// paymasterValidityArgs paymasterAndData 中的 abi.encode(validUntil, validAfter)
+var paymasterValidityArgs = abi.Arguments{
+	{Type: mustNewType("uint48")},
+	{Type: mustNewType("uint48")},
+}
+
+// VerifyingPaymaster 内置的 verifying paymaster 服务，按赞助策略为 UserOp 签发带有效期的 paymasterAndData
+type VerifyingPaymaster struct {
+	client     *ethclient.Client
+	Address    common.Address
+	key        *ecdsa.PrivateKey
+	accountAbi abi.ABI
+
+	allowedSenders map[common.Address]bool // 为空时不限制
+	allowedTargets map[common.Address]bool // 为空时不限制
+	senderDailyCap *big.Int                // 每个 sender 每日（UTC）最多赞助的 gas 费用，0 表示不限制
+	totalDailyCap  *big.Int                // 每日最多赞助的 gas 费用总额，0 表示不限制
+	validity       time.Duration
+
+	verificationGasLimit uint64
+	postOpGasLimit       uint64
+
+	mu            sync.Mutex
+	day           string
+	spentTotal    *big.Int // 当日已提交的赞助费用
+	spentBySender map[common.Address]*big.Int
+	reservations  map[sponsorshipKey]sponsorship
+}
+
+// NewVerifyingPaymaster 根据环境变量创建 verifying paymaster 服务，未配置 PAYMASTER_ADDRESS 时返回 nil
+func NewVerifyingPaymaster(client *ethclient.Client) (*VerifyingPaymaster, error) {
+	address := os.Getenv("PAYMASTER_ADDRESS")
+	if address == "" {
+		return nil, nil
+	}
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid PAYMASTER_ADDRESS: %q", address)
+	}
+
+	key, err := config.LoadPaymasterKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("PAYMASTER_KEYSTORE_PATH or PAYMASTER_PRIVATE_KEY is required when PAYMASTER_ADDRESS is set")
+	}
+
+	accountAbi, err := loadABI(os.Getenv("SimpleAccount_ABI"))
+	if err != nil {
+		return nil, err
+	}
+
+	allowedSenders, err := addressSetFromEnv("PAYMASTER_ALLOWED_SENDERS")
+	if err != nil {
+		return nil, err
+	}
+	allowedTargets, err := addressSetFromEnv("PAYMASTER_ALLOWED_TARGETS")
+	if err != nil {
+		return nil, err
+	}
+	senderDailyCap, err := optionalWeiFromEnv("PAYMASTER_SENDER_DAILY_CAP")
+	if err != nil {
+		return nil, err
+	}
+	totalDailyCap, err := optionalWeiFromEnv("PAYMASTER_TOTAL_DAILY_CAP")
+	if err != nil {
+		return nil, err
+	}
+
+	validity := defaultPaymasterValidity
+	if seconds, err := strconv.Atoi(os.Getenv("PAYMASTER_VALIDITY")); err == nil && seconds > 0 {
+		validity = time.Duration(seconds) * time.Second
+	}
+
+	return &VerifyingPaymaster{
+		client:               client,
+		Address:              common.HexToAddress(address),
+		key:                  key,
+		accountAbi:           accountAbi,
+		allowedSenders:       allowedSenders,
+		allowedTargets:       allowedTargets,
+		senderDailyCap:       senderDailyCap,
+		totalDailyCap:        totalDailyCap,
+		validity:             validity,
+		verificationGasLimit: uint64FromEnv("PAYMASTER_VERIFICATION_GAS_LIMIT", defaultPaymasterVerificationGasLimit),
+		postOpGasLimit:       uint64FromEnv("PAYMASTER_POSTOP_GAS_LIMIT", 0),
+		spentTotal:           big.NewInt(0),
+		spentBySender:        make(map[common.Address]*big.Int),
+		reservations:         make(map[sponsorshipKey]sponsorship),
+	}, nil
+}
+
+// HandleSponsorUserOperation pm_sponsorUserOperation 的 JSON-RPC 处理函数，参数为 [userOp, entryPoint]
+func (pm *VerifyingPaymaster) HandleSponsorUserOperation(params []json.RawMessage) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, NewBundlerError(ErrCodeInvalidParams, "expected params [userOp, entryPoint]")
+	}
+	var userOp models.PackedUserOperation
+	if err := json.Unmarshal(params[0], &userOp); err != nil {
+		return nil, invalidParams(err)
+	}
+	if err := checkEntryPoint(params[1]); err != nil {
+		return nil, err
+	}
+	return pm.Sponsor(userOp)
+}
+
+// Sponsor 检查赞助策略，通过后签发 paymasterAndData
+// 返回的数据只对当前的 gas 参数有效，UserOp 的其他字段（签名除外）修改后需要重新申请
+// 签发时只预留额度，UserOp 提交后由 Commit 计入当日花费，有效期内没有提交的预留会被退回
+func (pm *VerifyingPaymaster) Sponsor(userOp models.PackedUserOperation) (*models.SponsorshipResult, error) {
+	initCode, callData, accountGasLimits, gasFees, _, _, err := decodeUserOp(userOp)
+	if err != nil {
+		return nil, err
+	}
+	if len(accountGasLimits) != 32 || len(gasFees) != 32 {
+		return nil, NewBundlerError(ErrCodeInvalidParams, "accountGasLimits and gasFees must be 32 bytes")
+	}
+
+	// 赞助策略：sender 与调用目标白名单
+	if len(pm.allowedSenders) > 0 && !pm.allowedSenders[userOp.Sender] {
+		return nil, NewBundlerError(ErrCodePaymaster, "sender %s is not eligible for sponsorship", userOp.Sender.Hex())
+	}
+	if len(pm.allowedTargets) > 0 {
+		targets, err := pm.callTargets(callData)
+		if err != nil {
+			return nil, NewBundlerError(ErrCodePaymaster, "cannot sponsor callData: %v", err)
+		}
+		for _, target := range targets {
+			if !pm.allowedTargets[target] {
+				return nil, NewBundlerError(ErrCodePaymaster, "target %s is not eligible for sponsorship", target.Hex())
+			}
+		}
+	}
+
+	// 按最大 gas 费用预留每日额度，有效期结束时未提交则退回
+	verificationGasLimit := new(big.Int).SetBytes(accountGasLimits[:16])
+	callGasLimit := new(big.Int).SetBytes(accountGasLimits[16:])
+	maxFeePerGas := new(big.Int).SetBytes(gasFees[16:])
+	totalGas := new(big.Int).Add(verificationGasLimit, callGasLimit)
+	totalGas.Add(totalGas, userOp.PreVerificationGas)
+	totalGas.Add(totalGas, new(big.Int).SetUint64(pm.verificationGasLimit+pm.postOpGasLimit))
+	maxCost := new(big.Int).Mul(totalGas, maxFeePerGas)
+	now := time.Now()
+	key := sponsorshipKey{sender: userOp.Sender, nonce: userOp.Nonce.String()}
+	if err := pm.reserve(key, maxCost, now.Add(pm.validity)); err != nil {
+		return nil, err
+	}
+
+	chainID, err := pm.client.ChainID(context.Background())
+	if err != nil {
+		pm.release(key)
+		return nil, fmt.Errorf("error getting chain ID: %v", err)
+	}
+
+	validAfter := big.NewInt(now.Add(-paymasterClockSkew).Unix())
+	validUntil := big.NewInt(now.Add(pm.validity).Unix())
+	paymasterGasLimits := packUint128Pair(new(big.Int).SetUint64(pm.verificationGasLimit), new(big.Int).SetUint64(pm.postOpGasLimit))
+
+	encoded, err := paymasterHashArgs.Pack(
+		userOp.Sender,
+		userOp.Nonce,
+		crypto.Keccak256Hash(initCode),
+		crypto.Keccak256Hash(callData),
+		toFixedSizeByteArray(accountGasLimits),
+		new(big.Int).SetBytes(paymasterGasLimits),
+		userOp.PreVerificationGas,
+		toFixedSizeByteArray(gasFees),
+		chainID,
+		pm.Address,
+		validUntil,
+		validAfter,
+	)
+	if err != nil {
+		pm.release(key)
+		return nil, fmt.Errorf("error encoding paymaster hash: %v", err)
+	}
+
+	// 合约使用 toEthSignedMessageHash 校验，签名的 v 值为 27/28
+	signature, err := crypto.Sign(accounts.TextHash(crypto.Keccak256(encoded)), pm.key)
+	if err != nil {
+		pm.release(key)
+		return nil, fmt.Errorf("error signing paymaster data: %v", err)
+	}
+	signature[64] += 27
+
+	validityData, err := paymasterValidityArgs.Pack(validUntil, validAfter)
+	if err != nil {
+		pm.release(key)
+		return nil, fmt.Errorf("error encoding validity: %v", err)
+	}
+
+	// paymasterAndData = paymaster | 验证 gas (16) | postOp gas (16) | abi.encode(validUntil, validAfter) | signature
+	paymasterAndData := append(pm.Address.Bytes(), paymasterGasLimits...)
+	paymasterAndData = append(paymasterAndData, validityData...)
+	paymasterAndData = append(paymasterAndData, signature...)
+
+	return &models.SponsorshipResult{
+		PaymasterAndData:              hexutil.Encode(paymasterAndData),
+		PaymasterVerificationGasLimit: hexutil.EncodeUint64(pm.verificationGasLimit),
+		PaymasterPostOpGasLimit:       hexutil.EncodeUint64(pm.postOpGasLimit),
+		ValidUntil:                    hexutil.EncodeBig(validUntil),
+		ValidAfter:                    hexutil.EncodeBig(validAfter),
+	}, nil
+}
+
+// callTargets 从 SimpleAccount 的 execute / executeBatch callData 中取出调用目标
+func (pm *VerifyingPaymaster) callTargets(callData []byte) ([]common.Address, error) {
+	if len(callData) == 0 {
+		return nil, nil
+	}
+	if len(callData) < 4 {
+		return nil, fmt.Errorf("callData too short")
+	}
+
+	method, err := pm.accountAbi.MethodById(callData[:4])
+	if err != nil {
+		return nil, fmt.Errorf("unknown account method %s", hexutil.Encode(callData[:4]))
+	}
+	args, err := method.Inputs.Unpack(callData[4:])
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking %s: %v", method.Name, err)
+	}
+
+	switch method.Name {
+	case "execute":
+		return []common.Address{args[0].(common.Address)}, nil
+	case "executeBatch":
+		return args[0].([]common.Address), nil
+	default:
+		return nil, fmt.Errorf("unsupported account method %s", method.Name)
+	}
+}
+
+// Commit 在使用本 paymaster 的 UserOp 提交后，将对应的预留计入当日花费
+func (pm *VerifyingPaymaster) Commit(userOp models.PackedUserOperation) {
+	paymasterAndData, err := hexutil.Decode(userOp.PaymasterAndData)
+	if err != nil || len(paymasterAndData) < common.AddressLength || common.BytesToAddress(paymasterAndData[:common.AddressLength]) != pm.Address {
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	key := sponsorshipKey{sender: userOp.Sender, nonce: userOp.Nonce.String()}
+	reservation, ok := pm.reservations[key]
+	if !ok {
+		return
+	}
+	delete(pm.reservations, key)
+
+	pm.resetDay()
+	spent, ok := pm.spentBySender[key.sender]
+	if !ok {
+		spent = big.NewInt(0)
+		pm.spentBySender[key.sender] = spent
+	}
+	spent.Add(spent, reservation.amount)
+	pm.spentTotal.Add(pm.spentTotal, reservation.amount)
+}
+
+// reserve 在每日额度内预留赞助费用，已提交的花费加上未过期的预留超出 sender 或总额上限时拒绝
+func (pm *VerifyingPaymaster) reserve(key sponsorshipKey, amount *big.Int, expiresAt time.Time) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.resetDay()
+
+	// 退回已过期的预留；同一个 UserOp 重新申请时替换原来的预留
+	now := time.Now()
+	for k, reservation := range pm.reservations {
+		if !now.Before(reservation.expiresAt) {
+			delete(pm.reservations, k)
+		}
+	}
+	delete(pm.reservations, key)
+
+	usedBySender, usedTotal := big.NewInt(0), new(big.Int).Set(pm.spentTotal)
+	if spent, ok := pm.spentBySender[key.sender]; ok {
+		usedBySender.Set(spent)
+	}
+	for k, reservation := range pm.reservations {
+		usedTotal.Add(usedTotal, reservation.amount)
+		if k.sender == key.sender {
+			usedBySender.Add(usedBySender, reservation.amount)
+		}
+	}
+
+	if pm.senderDailyCap.Sign() > 0 && usedBySender.Add(usedBySender, amount).Cmp(pm.senderDailyCap) > 0 {
+		return NewBundlerError(ErrCodePaymaster, "daily sponsorship cap reached for sender %s", key.sender.Hex())
+	}
+	if pm.totalDailyCap.Sign() > 0 && usedTotal.Add(usedTotal, amount).Cmp(pm.totalDailyCap) > 0 {
+		return NewBundlerError(ErrCodePaymaster, "daily sponsorship cap reached")
+	}
+
+	pm.reservations[key] = sponsorship{amount: amount, expiresAt: expiresAt}
+	return nil
+}
+
+// release 退回预留但未签发的额度
+func (pm *VerifyingPaymaster) release(key sponsorshipKey) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	delete(pm.reservations, key)
+}
+
+// resetDay 进入新的一天（UTC）时清零已提交的花费，调用方需持有 pm.mu
+func (pm *VerifyingPaymaster) resetDay() {
+	today := time.Now().UTC().Format("2006-01-02")
+	if pm.day != today {
+		pm.day = today
+		pm.spentTotal = big.NewInt(0)
+		pm.spentBySender = make(map[common.Address]*big.Int)
+	}
+}
+
+// addressSetFromEnv 读取以逗号分隔的地址列表
+func addressSetFromEnv(key string) (map[common.Address]bool, error) {
+	set := make(map[common.Address]bool)
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !common.IsHexAddress(item) {
+			return nil, fmt.Errorf("invalid address in %s: %q", key, item)
+		}
+		set[common.HexToAddress(item)] = true
+	}
+	return set, nil
+}
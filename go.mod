@@ -6,6 +6,7 @@ require (
 	github.com/ethereum/go-ethereum v1.10.19
 	github.com/gin-gonic/gin v1.7.7
 	github.com/joho/godotenv v1.4.0
+	golang.org/x/term v0.15.0
 )
 
 require (
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"bundler/config"
 	"bundler/controllers"
@@ -13,6 +19,11 @@ import (
 func main() {
 	config.LoadEnv() // 加载环境变量
 
+	// 加载执行者签名器，本地私钥只读取一次并保存在内存中
+	if err := controllers.LoadSigner(); err != nil {
+		log.Fatalf("Failed to load executor signer: %v", err)
+	}
+
 	r := gin.Default()
 
 	// 创建 UserOpController 实例
@@ -21,11 +32,19 @@ func main() {
 		log.Fatalf("Failed to create UserOpController: %v", err)
 	}
 
+	// 每个新区块检查执行者余额
+	balanceMonitor, err := controllers.NewBalanceMonitor(userOpController.Client, userOpController.Executors)
+	if err != nil {
+		log.Fatalf("Failed to create BalanceMonitor: %v", err)
+	}
+	go balanceMonitor.Run()
+
 	// 连接以太坊客户端和设置控制器
-	publicKeyOracleController, err := controllers.NewPublicKeyOracleController()
+	publicKeyOracleController, err := controllers.NewPublicKeyOracleController(userOpController.Executors.Nonces)
 	if err != nil {
 		log.Fatalf("Failed to create UserOpController: %v", err)
 	}
+	publicKeyOracleController.CheckOwner()
 
 	// 创建 DepositController 实例
 	depositController, err := controllers.NewDepositController()
@@ -33,14 +52,97 @@ func main() {
 		log.Fatalf("Failed to create UserOpController: %v", err)
 	}
 
+	// 按配置启动存款自动补充任务
+	topUpJob, err := controllers.NewDepositTopUpJob(depositController)
+	if err != nil {
+		log.Fatalf("Failed to create DepositTopUpJob: %v", err)
+	}
+	if topUpJob != nil {
+		go topUpJob.Run()
+	}
+
+	// 创建 DKIM 公钥获取与轮换同步任务
+	dkimKeyFetcher := controllers.NewDKIMKeyFetcher(publicKeyOracleController)
+	dkimKeySync, err := controllers.NewDKIMKeySync(dkimKeyFetcher)
+	if err != nil {
+		log.Fatalf("Failed to create DKIMKeySync: %v", err)
+	}
+	go dkimKeySync.Run()
+	dkimVerifier := controllers.NewDKIMVerifier(publicKeyOracleController)
+
+	// 配置了起始区块时创建预言机公钥索引器
+	oracleKeyIndexer, err := controllers.NewOracleKeyIndexer()
+	if err != nil {
+		log.Fatalf("Failed to create OracleKeyIndexer: %v", err)
+	}
+	if oracleKeyIndexer != nil {
+		go oracleKeyIndexer.Run()
+	}
+
+	// 配置了邮件恢复账户 ABI 时创建邮件恢复 UserOp 构建器
+	emailRecoveryBuilder, err := controllers.NewEmailRecoveryBuilder(userOpController, dkimVerifier)
+	if err != nil {
+		log.Fatalf("Failed to create EmailRecoveryBuilder: %v", err)
+	}
+
+	// 配置了恢复模块时创建 RecoveryController 并开始跟踪恢复事件
+	recoveryController, err := controllers.NewRecoveryController(userOpController.Executors.Nonces)
+	if err != nil {
+		log.Fatalf("Failed to create RecoveryController: %v", err)
+	}
+	if recoveryController != nil {
+		go recoveryController.Run()
+	}
+
+	// 创建 ERC-4337 JSON-RPC 接口
+	rpcController := controllers.NewRPCController(userOpController)
+
+	// 配置了 paymaster 时提供 pm_sponsorUserOperation 赞助服务
+	verifyingPaymaster, err := controllers.NewVerifyingPaymaster(userOpController.Client)
+	if err != nil {
+		log.Fatalf("Failed to create VerifyingPaymaster: %v", err)
+	}
+	if verifyingPaymaster != nil {
+		rpcController.Register("pm_sponsorUserOperation", verifyingPaymaster.HandleSponsorUserOperation)
+		userOpController.VerifyingPaymaster = verifyingPaymaster
+	}
+
 	// 初始化路由
 	routes.SetupRouter(r)
 	routes.SetupUserOpRouter(r, userOpController)
+	routes.SetupBalanceMonitorRouter(r, balanceMonitor)
+	routes.SetupRPCRouter(r, rpcController)
 	routes.SetupDepositRouter(r, depositController)
-	routes.SetupPublicKeyOracleRouter(r, publicKeyOracleController)
+	if recoveryController != nil {
+		routes.SetupRecoveryRouter(r, recoveryController)
+	}
+	routes.SetupPublicKeyOracleRouter(r, publicKeyOracleController, dkimKeyFetcher, dkimKeySync, dkimVerifier)
+	if oracleKeyIndexer != nil {
+		routes.SetupOracleKeyCatalogRouter(r, oracleKeyIndexer)
+	}
+	if emailRecoveryBuilder != nil {
+		routes.SetupEmailRecoveryRouter(r, emailRecoveryBuilder)
+	}
 
 	// 运行服务器
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to run server: %v", err)
+		}
+	}()
+
+	// 等待退出信号，关闭前保存内存池快照
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+	if err := userOpController.Mempool.Save(); err != nil {
+		log.Printf("Failed to save mempool snapshot: %v", err)
 	}
 }
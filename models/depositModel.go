@@ -0,0 +1,70 @@
+package models
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DepositInfo EntryPoint 中账户的存款与质押信息
+type DepositInfo struct {
+	Deposit         *big.Int `json:"deposit"`
+	Staked          bool     `json:"staked"`
+	Stake           *big.Int `json:"stake"`
+	UnstakeDelaySec uint32   `json:"unstakeDelaySec"`
+	WithdrawTime    *big.Int `json:"withdrawTime"`
+}
+
+// DepositStatus depositTo 交易的确认状态及 Deposited 事件解析结果
+type DepositStatus struct {
+	TransactionHash string   `json:"transactionHash"`
+	Status          string   `json:"status"` // pending / success / failed
+	BlockNumber     *big.Int `json:"blockNumber,omitempty"`
+	Account         string   `json:"account,omitempty"`
+	TotalDeposit    *big.Int `json:"totalDeposit,omitempty"`
+}
+
+// StakeStatus 质押与提取交易的确认状态，Stake / UnstakeDelaySec / WithdrawTime 为交易所在区块的 getDepositInfo 结果
+type StakeStatus struct {
+	TransactionHash string   `json:"transactionHash"`
+	Status          string   `json:"status"` // success / failed
+	BlockNumber     *big.Int `json:"blockNumber,omitempty"`
+	Event           string   `json:"event"` // StakeLocked / StakeUnlocked / StakeWithdrawn / Withdrawn
+	Account         string   `json:"account,omitempty"`
+	WithdrawAddress string   `json:"withdrawAddress,omitempty"` // StakeWithdrawn / Withdrawn 的收款地址
+	Amount          *big.Int `json:"amount,omitempty"`          // StakeWithdrawn / Withdrawn 提取的金额
+	Deposit         *big.Int `json:"deposit,omitempty"`
+	Stake           *big.Int `json:"stake,omitempty"`
+	UnstakeDelaySec uint32   `json:"unstakeDelaySec"`
+	WithdrawTime    *big.Int `json:"withdrawTime,omitempty"`
+}
+
+// 补充存款审计记录的状态
+const (
+	TopUpSent     = "sent"     // 交易已发出，额度计入当天花费
+	TopUpFailed   = "failed"   // 交易未能发出
+	TopUpReplaced = "replaced" // 长时间未确认，以相同 nonce 加价替换
+	TopUpDropped  = "dropped"  // 交易已丢失，额度退回
+)
+
+// TopUpRecord 自动补充存款的审计记录
+type TopUpRecord struct {
+	Time            int64    `json:"time"`
+	Address         string   `json:"address"`
+	Status          string   `json:"status,omitempty"` // 早期记录没有该字段，带交易哈希的即为 sent
+	Balance         *big.Int `json:"balance,omitempty"`
+	Amount          *big.Int `json:"amount"`
+	TransactionHash string   `json:"transactionHash,omitempty"`
+	Replaces        string   `json:"replaces,omitempty"` // 被替换的交易哈希
+	Error           string   `json:"error,omitempty"`
+}
+
+// PendingTopUp 已发出但尚未确认的补充交易，保存在状态文件中，重启后继续跟踪
+type PendingTopUp struct {
+	TxHashes []common.Hash `json:"txHashes"` // 原交易及其替换交易，最后一个为最新发出的
+	Nonce    uint64        `json:"nonce"`
+	GasPrice *big.Int      `json:"gasPrice"`
+	Amount   *big.Int      `json:"amount"`
+	Day      string        `json:"day"`    // 预留额度所属的日期（UTC）
+	SentAt   int64         `json:"sentAt"` // 最近一次发出的时间
+}
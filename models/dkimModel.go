@@ -0,0 +1,83 @@
+package models
+
+// DKIMKeyID 一个 DKIM 公钥的定位信息
+type DKIMKeyID struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector"`
+}
+
+// DKIMRotation 一次 DKIM 公钥轮换记录
+type DKIMRotation struct {
+	Domain          string `json:"domain"`
+	Selector        string `json:"selector"`
+	OldModulus      string `json:"oldModulus"`
+	NewModulus      string `json:"newModulus"`
+	NewExponent     string `json:"newExponent"`
+	TransactionHash string `json:"transactionHash"`
+	RotatedAt       int64  `json:"rotatedAt"`
+}
+
+// DKIMSyncState DKIM 同步任务落盘的状态
+type DKIMSyncState struct {
+	Tracked   []DKIMKeyID    `json:"tracked"`
+	Rotations []DKIMRotation `json:"rotations"`
+}
+
+// PublicKeyEntry 写入 PublicKeyOracle 的一条公钥
+// 可以直接提供 Modulus/Exponent，也可以提供 PEM、base64 或十六进制 DER 格式的 PublicKey
+type PublicKeyEntry struct {
+	Domain    string `json:"domain"`
+	Selector  string `json:"selector"`
+	Modulus   []byte `json:"modulus"`
+	Exponent  []byte `json:"exponent"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// PublicKeyResult 批量写入时每条公钥的结果
+type PublicKeyResult struct {
+	Domain          string `json:"domain"`
+	Selector        string `json:"selector"`
+	TransactionHash string `json:"transactionHash,omitempty"`
+	Nonce           uint64 `json:"nonce,omitempty"`
+	Error           string `json:"error,omitempty"`
+	Code            int    `json:"code,omitempty"` // 失败时的 ERC-4337 / JSON-RPC 错误码
+}
+
+// SignedHeader DKIM 签名覆盖的一个邮件头
+type SignedHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DKIMVerification 一个 DKIM-Signature 的验证结果
+type DKIMVerification struct {
+	Domain           string         `json:"domain"`
+	Selector         string         `json:"selector"`
+	Algorithm        string         `json:"algorithm"`
+	Canonicalization string         `json:"canonicalization"`
+	Timestamp        int64          `json:"timestamp,omitempty"`  // t= 签名时间（Unix 秒）
+	Expiration       int64          `json:"expiration,omitempty"` // x= 过期时间（Unix 秒）
+	SignedHeaders    []SignedHeader `json:"signedHeaders"`
+	BodyHashValid    bool           `json:"bodyHashValid"`
+	SignatureValid   bool           `json:"signatureValid"`
+	Error            string         `json:"error,omitempty"`
+
+	// 以下字段供链上验签使用，不在接口中返回
+	SignedData []byte `json:"-"` // 规范化后参与签名的头部数据
+	Signature  []byte `json:"-"` // b= 标签中的 RSA 签名
+}
+
+// OracleKeyRecord 索引到的预言机公钥，Fingerprint 为 sha256(modulus || exponent)
+type OracleKeyRecord struct {
+	Domain          string `json:"domain"`
+	Selector        string `json:"selector"`
+	Fingerprint     string `json:"fingerprint"`
+	SetAtBlock      uint64 `json:"setAtBlock"`
+	TransactionHash string `json:"transactionHash"`
+}
+
+// OracleIndexState 预言机索引器落盘的状态
+type OracleIndexState struct {
+	NextBlock uint64                     `json:"nextBlock"`
+	Keys      map[string]OracleKeyRecord `json:"keys"`
+}
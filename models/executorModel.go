@@ -0,0 +1,24 @@
+package models
+
+import (
+	"math/big"
+)
+
+// ExecutorBalance 执行者账户的余额状态
+type ExecutorBalance struct {
+	Address     string   `json:"address"`
+	Balance     *big.Int `json:"balance"`
+	Level       string   `json:"level"` // ok / warning / critical
+	Paused      bool     `json:"paused"`
+	BlockNumber uint64   `json:"blockNumber"`
+}
+
+// BalanceAlert 执行者余额告警，通过 webhook 发送
+type BalanceAlert struct {
+	Time        int64    `json:"time"`
+	Address     string   `json:"address"`
+	Level       string   `json:"level"` // warning / critical / recovered
+	Balance     *big.Int `json:"balance"`
+	Threshold   *big.Int `json:"threshold"`
+	BlockNumber uint64   `json:"blockNumber"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// InFlightBundle 已发送但尚未确认的 bundle 交易
+type InFlightBundle struct {
+	TxHash   common.Hash           `json:"txHash"`
+	Executor common.Address        `json:"executor"`
+	UserOps  []PackedUserOperation `json:"userOps"`
+	SentAt   int64                 `json:"sentAt"`
+}
+
+// MempoolSnapshot 内存池落盘快照
+type MempoolSnapshot struct {
+	InFlight []InFlightBundle `json:"inFlight"`
+	SavedAt  int64            `json:"savedAt"`
+}
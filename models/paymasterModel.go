@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// SponsorshipResult pm_sponsorUserOperation 的返回值，数值均为 0x 十六进制
+type SponsorshipResult struct {
+	PaymasterAndData              string `json:"paymasterAndData"`
+	PaymasterVerificationGasLimit string `json:"paymasterVerificationGasLimit"`
+	PaymasterPostOpGasLimit       string `json:"paymasterPostOpGasLimit"`
+	ValidUntil                    string `json:"validUntil"`
+	ValidAfter                    string `json:"validAfter"`
+}
+
+// PaymasterService UserOp 附带的 ERC-7677 paymaster 服务信息
+type PaymasterService struct {
+	URL     string          `json:"url"`
+	Context json.RawMessage `json:"context,omitempty"`
+}
+
+// UnpackedUserOperation ERC-7677 / RPC 使用的 v0.7 非打包 UserOp 格式
+type UnpackedUserOperation struct {
+	Sender                        common.Address  `json:"sender"`
+	Nonce                         *hexutil.Big    `json:"nonce"`
+	Factory                       *common.Address `json:"factory,omitempty"`
+	FactoryData                   hexutil.Bytes   `json:"factoryData,omitempty"`
+	CallData                      hexutil.Bytes   `json:"callData"`
+	CallGasLimit                  *hexutil.Big    `json:"callGasLimit"`
+	VerificationGasLimit          *hexutil.Big    `json:"verificationGasLimit"`
+	PreVerificationGas            *hexutil.Big    `json:"preVerificationGas"`
+	MaxFeePerGas                  *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas          *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	Paymaster                     *common.Address `json:"paymaster,omitempty"`
+	PaymasterVerificationGasLimit *hexutil.Big    `json:"paymasterVerificationGasLimit,omitempty"`
+	PaymasterPostOpGasLimit       *hexutil.Big    `json:"paymasterPostOpGasLimit,omitempty"`
+	PaymasterData                 hexutil.Bytes   `json:"paymasterData,omitempty"`
+	Signature                     hexutil.Bytes   `json:"signature"`
+}
+
+// PaymasterStubData pm_getPaymasterStubData 的返回值
+type PaymasterStubData struct {
+	Paymaster                     common.Address `json:"paymaster"`
+	PaymasterData                 hexutil.Bytes  `json:"paymasterData"`
+	PaymasterVerificationGasLimit *hexutil.Big   `json:"paymasterVerificationGasLimit"`
+	PaymasterPostOpGasLimit       *hexutil.Big   `json:"paymasterPostOpGasLimit"`
+	IsFinal                       bool           `json:"isFinal"`
+	Sponsor                       *struct {
+		Name string `json:"name"`
+		Icon string `json:"icon,omitempty"`
+	} `json:"sponsor,omitempty"`
+}
+
+// PaymasterData pm_getPaymasterData 的返回值
+type PaymasterData struct {
+	Paymaster     common.Address `json:"paymaster"`
+	PaymasterData hexutil.Bytes  `json:"paymasterData"`
+}
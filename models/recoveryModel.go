@@ -0,0 +1,29 @@
+package models
+
+// Recovery 一次社交恢复的状态，由恢复模块的事件更新
+type Recovery struct {
+	RecoveryID    string   `json:"recoveryId"`
+	Account       string   `json:"account"`
+	NewOwner      string   `json:"newOwner"`
+	Guardians     []string `json:"guardians"`     // 已确认的守护者，包括发起者
+	Confirmations uint64   `json:"confirmations"` // 合约事件中记录的确认数
+	Status        string   `json:"status"`        // pending / executed / cancelled
+	ProposedTx    string   `json:"proposedTx"`
+	ExecutedTx    string   `json:"executedTx,omitempty"`
+	UpdatedBlock  uint64   `json:"updatedBlock"`
+}
+
+// RecoveryCall 需要守护者签名的恢复模块调用
+type RecoveryCall struct {
+	Guardian string `json:"guardian"`
+	To       string `json:"to"`   // 恢复模块地址，守护者为 EOA 时直接发送交易
+	Data     string `json:"data"` // 调用恢复模块的 calldata
+	// UserOpCallData 守护者为智能账户时，作为 UserOp 的 callData：execute(module, 0, data)
+	UserOpCallData string `json:"userOpCallData"`
+}
+
+// RecoveryState 恢复事件索引的持久化状态
+type RecoveryState struct {
+	NextBlock  uint64               `json:"nextBlock"`  // 下一个待查询的区块
+	Recoveries map[string]*Recovery `json:"recoveries"` // 以 recoveryId 为键
+}
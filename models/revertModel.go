@@ -0,0 +1,18 @@
+package models
+
+// RevertArg 自定义错误的一个参数
+type RevertArg struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// RevertReason 解码后的 revert 数据，Inner 为参数中嵌套的内层 revert
+type RevertReason struct {
+	Selector string        `json:"selector"`
+	Name     string        `json:"name,omitempty"` // 未知选择器时为空
+	Args     []RevertArg   `json:"args,omitempty"`
+	Message  string        `json:"message"`
+	Inner    *RevertReason `json:"inner,omitempty"`
+	Raw      string        `json:"raw"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// RPCRequest JSON-RPC 2.0 请求
+type RPCRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// RPCResponse JSON-RPC 2.0 响应，Result 与 Error 只会出现一个
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   interface{}     `json:"error,omitempty"`
+}
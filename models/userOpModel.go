@@ -1,20 +1,112 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
 	"math/big"
+	"regexp"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 // PackedUserOperation 结构体
 type PackedUserOperation struct {
-	Sender             common.Address `bson:"sender"`
-	Nonce              *big.Int       `bson:"nonce"`
-	InitCode           string         `bson:"initCode"`
-	CallData           string         `bson:"callData"`
-	AccountGasLimits   string         `bson:"accountGasLimits"`
-	PreVerificationGas *big.Int       `bson:"preVerificationGas"`
-	GasFees            string         `bson:"gasFees"`
-	PaymasterAndData   string         `bson:"paymasterAndData"`
-	Signature          string         `bson:"signature"`
+	Sender             common.Address `json:"sender" bson:"sender"`
+	Nonce              *big.Int       `json:"nonce" bson:"nonce"` // 高 192 位为 key，低 64 位为序号
+	InitCode           string         `json:"initCode" bson:"initCode"`
+	CallData           string         `json:"callData" bson:"callData"`
+	AccountGasLimits   string         `json:"accountGasLimits" bson:"accountGasLimits"`
+	PreVerificationGas *big.Int       `json:"preVerificationGas" bson:"preVerificationGas"`
+	GasFees            string         `json:"gasFees" bson:"gasFees"`
+	PaymasterAndData   string         `json:"paymasterAndData" bson:"paymasterAndData"`
+	Signature          string         `json:"signature" bson:"signature"`
+}
+
+// MarshalJSON 以 0x 十六进制字符串输出 nonce 和 preVerificationGas，与 UnmarshalJSON 对应
+// 返回的 UserOp 可以原样提交，JS 客户端也不会因为 JSON 数字丢失 192 位 key 的精度
+func (op PackedUserOperation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Sender             common.Address `json:"sender"`
+		Nonce              *hexutil.Big   `json:"nonce"`
+		InitCode           string         `json:"initCode"`
+		CallData           string         `json:"callData"`
+		AccountGasLimits   string         `json:"accountGasLimits"`
+		PreVerificationGas *hexutil.Big   `json:"preVerificationGas"`
+		GasFees            string         `json:"gasFees"`
+		PaymasterAndData   string         `json:"paymasterAndData"`
+		Signature          string         `json:"signature"`
+	}{
+		Sender:             op.Sender,
+		Nonce:              (*hexutil.Big)(op.Nonce),
+		InitCode:           op.InitCode,
+		CallData:           op.CallData,
+		AccountGasLimits:   op.AccountGasLimits,
+		PreVerificationGas: (*hexutil.Big)(op.PreVerificationGas),
+		GasFees:            op.GasFees,
+		PaymasterAndData:   op.PaymasterAndData,
+		Signature:          op.Signature,
+	})
+}
+
+// UnmarshalJSON 允许 nonce 和 preVerificationGas 以 JSON 数字、十进制字符串或 0x 十六进制字符串传入，保留完整的 uint256 精度
+func (op *PackedUserOperation) UnmarshalJSON(data []byte) error {
+	type plain PackedUserOperation
+	aux := struct {
+		*plain
+		Nonce              json.RawMessage `json:"nonce"`
+		PreVerificationGas json.RawMessage `json:"preVerificationGas"`
+	}{plain: (*plain)(op)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if op.Nonce, err = parseUint256(aux.Nonce); err != nil {
+		return fmt.Errorf("invalid nonce: %v", err)
+	}
+	if op.PreVerificationGas, err = parseUint256(aux.PreVerificationGas); err != nil {
+		return fmt.Errorf("invalid preVerificationGas: %v", err)
+	}
+	return nil
+}
+
+// uint256 只接受 0x 十六进制或十进制，不接受 big.Int.SetString 以 0 为进制时允许的 _ 分隔符以及 0b / 0o 前缀
+var (
+	hexUint256Pattern     = regexp.MustCompile(`^0[xX][0-9a-fA-F]+$`)
+	decimalUint256Pattern = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// parseUint256 解析 JSON 数字或字符串形式的 uint256，字段缺失或为 null 时返回 nil
+func parseUint256(raw json.RawMessage) (*big.Int, error) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" || text == "null" {
+		return nil, nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return nil, err
+		}
+	}
+	return ParseUint256(text)
+}
+
+// ParseUint256 解析 0x 十六进制或十进制字符串形式的 uint256
+func ParseUint256(text string) (*big.Int, error) {
+	var value *big.Int
+	ok := false
+	switch {
+	case hexUint256Pattern.MatchString(text):
+		value, ok = new(big.Int).SetString(text[2:], 16)
+	case decimalUint256Pattern.MatchString(text):
+		value, ok = new(big.Int).SetString(text, 10)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%q is not a 0x-prefixed hex or decimal number", text)
+	}
+	if value.BitLen() > 256 {
+		return nil, fmt.Errorf("%q is out of uint256 range", text)
+	}
+	return value, nil
 }
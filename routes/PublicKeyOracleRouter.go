@@ -2,32 +2,55 @@ package routes
 
 import (
 	"bundler/controllers"
+	"bundler/models"
 	"net/http"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gin-gonic/gin"
 )
 
 // SetupPublicKeyOracleRouter 设置公钥预言机路由
-func SetupPublicKeyOracleRouter(r *gin.Engine, publicKeyOracleController *controllers.PublicKeyOracleController) {
+func SetupPublicKeyOracleRouter(r *gin.Engine, publicKeyOracleController *controllers.PublicKeyOracleController, dkimKeyFetcher *controllers.DKIMKeyFetcher, dkimKeySync *controllers.DKIMKeySync, dkimVerifier *controllers.DKIMVerifier) {
 	r.POST("/publicKeyOracle/setPublicKey", func(c *gin.Context) {
+		var request models.PublicKeyEntry
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		// 支持 PEM、base64 或十六进制 DER 格式的公钥，校验失败时直接返回 400，不发送交易
+		modulus, exponent, err := controllers.ResolveRSAKey(request)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		txHash, err := publicKeyOracleController.SetPublicKey(request.Domain, request.Selector, modulus, exponent)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction sent successfully", "transactionHash": txHash})
+	})
+
+	r.POST("/publicKeyOracle/setPublicKeys", func(c *gin.Context) {
 		var request struct {
-			Domain   string `json:"domain"`
-			Selector string `json:"selector"`
-			Modulus  []byte `json:"modulus"`
-			Exponent []byte `json:"exponent"`
+			Keys []models.PublicKeyEntry `json:"keys" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			controllers.RespondInvalidParams(c, "%v", err)
 			return
 		}
 
-		txHash, err := publicKeyOracleController.SetPublicKey(request.Domain, request.Selector, request.Modulus, request.Exponent)
+		results, err := publicKeyOracleController.SetPublicKeys(request.Keys)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			controllers.RespondError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Transaction sent successfully", "transactionHash": txHash})
+		c.JSON(http.StatusOK, gin.H{"results": results})
 	})
 
 	r.GET("/publicKeyOracle/getRSAKey", func(c *gin.Context) {
@@ -36,17 +59,187 @@ func SetupPublicKeyOracleRouter(r *gin.Engine, publicKeyOracleController *contro
 			Selector string `form:"selector"`
 		}
 		if err := c.ShouldBindQuery(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			controllers.RespondInvalidParams(c, "%v", err)
 			return
 		}
 
 		// 调用获取RSA密钥的方法
-		txHash, modulus, exponent, err := publicKeyOracleController.GetRSAKey(request.Domain, request.Selector)
+		modulus, exponent, err := publicKeyOracleController.GetRSAKey(request.Domain, request.Selector)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"domain": request.Domain, "selector": request.Selector, "modulus": hexutil.Encode(modulus), "exponent": hexutil.Encode(exponent)})
+	})
+
+	r.POST("/publicKeyOracle/syncDKIMKey", func(c *gin.Context) {
+		var request struct {
+			Domain   string `json:"domain" binding:"required"`
+			Selector string `json:"selector" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		// 从 DNS 获取 DKIM 公钥并写入预言机
+		txHash, modulus, exponent, err := dkimKeyFetcher.SyncKey(request.Domain, request.Selector)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction sent successfully", "transactionHash": txHash, "modulus": hexutil.Encode(modulus), "exponent": hexutil.Encode(exponent)})
+	})
+
+	r.GET("/publicKeyOracle/tracked", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"tracked": dkimKeySync.Tracked()})
+	})
+
+	r.POST("/publicKeyOracle/tracked", func(c *gin.Context) {
+		var request struct {
+			Domain   string `json:"domain" binding:"required"`
+			Selector string `json:"selector" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if err := dkimKeySync.Track(request.Domain, request.Selector); err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		// 添加后立即同步一次
+		rotation, err := dkimKeySync.SyncOne(request.Domain, request.Selector)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Key tracked", "rotation": rotation})
+	})
+
+	r.GET("/publicKeyOracle/rotations", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"rotations": dkimKeySync.Rotations()})
+	})
+
+	r.GET("/publicKeyOracle/owner", func(c *gin.Context) {
+		owner, err := publicKeyOracleController.Owner()
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"owner": owner.Hex()})
+	})
+
+	r.POST("/publicKeyOracle/transferOwnership", func(c *gin.Context) {
+		var request struct {
+			NewOwner string `json:"newOwner" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.NewOwner) {
+			controllers.RespondInvalidParams(c, "invalid newOwner")
+			return
+		}
+
+		// 第一步只生成确认令牌，不发送交易
+		token, expiresAt, err := publicKeyOracleController.RequestOwnershipTransfer(common.HexToAddress(request.NewOwner))
+		if err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Confirm the transfer with /publicKeyOracle/transferOwnership/confirm", "confirmationToken": token, "expiresAt": expiresAt.Unix()})
+	})
+
+	r.POST("/publicKeyOracle/transferOwnership/confirm", func(c *gin.Context) {
+		var request struct {
+			ConfirmationToken string `json:"confirmationToken" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		txHash, newOwner, err := publicKeyOracleController.ConfirmOwnershipTransfer(request.ConfirmationToken)
+		if err == controllers.ErrInvalidConfirmationToken {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction sent successfully", "transactionHash": txHash, "newOwner": newOwner.Hex()})
+	})
+
+	r.POST("/publicKeyOracle/verifyEmail", func(c *gin.Context) {
+		// 支持 JSON {"email": "..."}，也支持直接以 message/rfc822 提交原始邮件
+		var message []byte
+		if c.ContentType() == "application/json" {
+			var request struct {
+				Email string `json:"email" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&request); err != nil {
+				controllers.RespondInvalidParams(c, "%v", err)
+				return
+			}
+			message = []byte(request.Email)
+		} else {
+			data, err := c.GetRawData()
+			if err != nil {
+				controllers.RespondInvalidParams(c, "%v", err)
+				return
+			}
+			message = data
+		}
+
+		results, err := dkimVerifier.Verify(message)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		verified := false
+		for _, result := range results {
+			if result.SignatureValid && result.BodyHashValid {
+				verified = true
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"verified": verified, "signatures": results})
+	})
+}
+
+// SetupOracleKeyCatalogRouter 设置预言机公钥目录路由
+func SetupOracleKeyCatalogRouter(r *gin.Engine, oracleKeyIndexer *controllers.OracleKeyIndexer) {
+	r.GET("/publicKeyOracle/keys", func(c *gin.Context) {
+		var request struct {
+			Domain   string `form:"domain"`
+			Page     int    `form:"page"`
+			PageSize int    `form:"pageSize"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Transaction sent successfully", "transactionHash": txHash, "modulus": modulus, "exponent": exponent})
+		if request.Page <= 0 {
+			request.Page = 1
+		}
+		if request.PageSize <= 0 || request.PageSize > 500 {
+			request.PageSize = 50
+		}
+
+		keys, total := oracleKeyIndexer.Keys(request.Domain, request.Page, request.PageSize)
+		c.JSON(http.StatusOK, gin.H{"keys": keys, "page": request.Page, "pageSize": request.PageSize, "total": total})
 	})
 }
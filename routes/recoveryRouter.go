@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"bundler/controllers"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupRecoveryRouter 设置社交恢复路由
+func SetupRecoveryRouter(r *gin.Engine, recoveryController *controllers.RecoveryController) {
+	r.POST("/recovery/propose", func(c *gin.Context) {
+		var request struct {
+			Guardian string `json:"guardian" binding:"required"`
+			Account  string `json:"account" binding:"required"`
+			NewOwner string `json:"newOwner" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.Guardian) || !common.IsHexAddress(request.Account) || !common.IsHexAddress(request.NewOwner) {
+			controllers.RespondInvalidParams(c, "invalid address")
+			return
+		}
+
+		call, err := recoveryController.BuildPropose(common.HexToAddress(request.Guardian), common.HexToAddress(request.Account), common.HexToAddress(request.NewOwner))
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, call)
+	})
+
+	r.POST("/recovery/confirm", func(c *gin.Context) {
+		var request struct {
+			Guardian   string `json:"guardian" binding:"required"`
+			Account    string `json:"account" binding:"required"`
+			RecoveryID string `json:"recoveryId" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.Guardian) || !common.IsHexAddress(request.Account) {
+			controllers.RespondInvalidParams(c, "invalid address")
+			return
+		}
+		recoveryID, ok := parseRecoveryID(request.RecoveryID)
+		if !ok {
+			controllers.RespondInvalidParams(c, "recoveryId must be 32-byte hex")
+			return
+		}
+
+		call, err := recoveryController.BuildConfirm(common.HexToAddress(request.Guardian), common.HexToAddress(request.Account), recoveryID)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, call)
+	})
+
+	r.POST("/recovery/execute", func(c *gin.Context) {
+		var request struct {
+			Account    string `json:"account" binding:"required"`
+			RecoveryID string `json:"recoveryId" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.Account) {
+			controllers.RespondInvalidParams(c, "invalid account")
+			return
+		}
+		recoveryID, ok := parseRecoveryID(request.RecoveryID)
+		if !ok {
+			controllers.RespondInvalidParams(c, "recoveryId must be 32-byte hex")
+			return
+		}
+
+		txHash, err := recoveryController.ExecuteRecovery(common.HexToAddress(request.Account), recoveryID)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Transaction sent successfully", "transactionHash": txHash})
+	})
+
+	r.GET("/recovery/status", func(c *gin.Context) {
+		var request struct {
+			Account string `form:"account"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.Account) {
+			controllers.RespondInvalidParams(c, "invalid account")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"account": common.HexToAddress(request.Account).Hex(), "recoveries": recoveryController.GetRecoveries(common.HexToAddress(request.Account))})
+	})
+}
+
+// parseRecoveryID 解析 0x 开头的 32 字节十六进制 recoveryId，HexToHash 会静默截断或补零，所以不能直接使用
+func parseRecoveryID(value string) (common.Hash, bool) {
+	data, err := hexutil.Decode(value)
+	if err != nil || len(data) != common.HashLength {
+		return common.Hash{}, false
+	}
+	return common.BytesToHash(data), true
+}
+
+// SetupEmailRecoveryRouter 设置邮件恢复 UserOp 构建路由
+func SetupEmailRecoveryRouter(r *gin.Engine, emailRecoveryBuilder *controllers.EmailRecoveryBuilder) {
+	r.POST("/userOp/emailRecovery", func(c *gin.Context) {
+		var request struct {
+			Email  string `json:"email" binding:"required"`
+			Submit bool   `json:"submit"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		userOp, err := emailRecoveryBuilder.Build([]byte(request.Email))
+		if err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !request.Submit {
+			c.JSON(http.StatusOK, gin.H{"userOp": userOp})
+			return
+		}
+
+		// 直接走 bundler 自身的提交流程
+		txHash, err := emailRecoveryBuilder.UserOps.SubmitUserOp(*userOp)
+		if err != nil {
+			bundlerErr := controllers.AsBundlerError(err)
+			c.JSON(bundlerErr.HTTPStatus(), gin.H{"error": bundlerErr.Message, "code": bundlerErr.Code, "data": bundlerErr.Data, "userOp": userOp})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "UserOp received and sent", "transactionHash": txHash, "userOp": userOp})
+	})
+}
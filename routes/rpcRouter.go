@@ -0,0 +1,14 @@
+// rpcRouter.go
+
+package routes
+
+import (
+	"bundler/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupRPCRouter 初始化 ERC-4337 JSON-RPC 路由
+func SetupRPCRouter(r *gin.Engine, rpcController *controllers.RPCController) {
+	r.POST("/rpc", rpcController.Handle)
+}
@@ -4,15 +4,111 @@ package routes
 
 import (
 	"bundler/controllers"
+	"bundler/models"
 	"math/big"
 	"net/http"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gin-gonic/gin"
 )
 
 func SetupUserOpRouter(r *gin.Engine, userOpController *controllers.UserOpController) {
 	r.POST("/userOp", userOpController.StoreUserOp)
+
+	r.POST("/userOp/senderAddress", func(c *gin.Context) {
+		var request struct {
+			InitCode string `json:"initCode" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		initCode, err := hexutil.Decode(request.InitCode)
+		if err != nil {
+			controllers.RespondInvalidParams(c, "invalid initCode")
+			return
+		}
+
+		sender, err := userOpController.GetSenderAddress(initCode)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sender": sender.Hex()})
+	})
+
+	// 通过 ERC-7677 paymaster 服务填充 paymasterAndData，钱包基于返回的 UserOp 签名后再提交
+	r.POST("/userOp/paymasterData", func(c *gin.Context) {
+		var request struct {
+			UserOp           models.PackedUserOperation `json:"userOp" binding:"required"`
+			PaymasterService *models.PaymasterService   `json:"paymasterService" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		userOp, err := userOpController.ApplyPaymasterService(request.UserOp, request.PaymasterService)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"userOp": userOp})
+	})
+
+	r.GET("/userOp/nonce", func(c *gin.Context) {
+		var request struct {
+			Sender string `form:"sender" binding:"required"`
+			Key    string `form:"key"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.Sender) {
+			controllers.RespondInvalidParams(c, "invalid sender")
+			return
+		}
+		// key 支持十进制或 0x 十六进制，默认为 0
+		key := big.NewInt(0)
+		if request.Key != "" {
+			var err error
+			if key, err = models.ParseUint256(request.Key); err != nil {
+				controllers.RespondInvalidParams(c, "invalid key: %v", err)
+				return
+			}
+		}
+
+		nonce, err := userOpController.GetNonce(common.HexToAddress(request.Sender), key)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		_, sequence := controllers.SplitNonce(nonce)
+		c.JSON(http.StatusOK, gin.H{
+			"sender":   common.HexToAddress(request.Sender).Hex(),
+			"key":      (*hexutil.Big)(key),
+			"nonce":    (*hexutil.Big)(nonce),
+			"sequence": hexutil.Uint64(sequence),
+		})
+	})
+}
+
+// SetupBalanceMonitorRouter 初始化执行者余额状态与指标路由
+func SetupBalanceMonitorRouter(r *gin.Engine, balanceMonitor *controllers.BalanceMonitor) {
+	r.GET("/executors/balance", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"executors": balanceMonitor.Balances(), "paused": balanceMonitor.Paused()})
+	})
+
+	r.GET("/metrics", func(c *gin.Context) {
+		c.String(http.StatusOK, balanceMonitor.Metrics())
+	})
 }
 
 // SetupDepositRouter 初始化存款路由
@@ -21,26 +117,186 @@ func SetupDepositRouter(r *gin.Engine, depositController *controllers.DepositCon
 		var request struct {
 			Address string `json:"address"`
 			Amount  string `json:"amount"`
+			Wait    bool   `json:"wait"`
 		}
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			controllers.RespondInvalidParams(c, "%v", err)
 			return
 		}
 
 		address := common.HexToAddress(request.Address)
 		amount, ok := new(big.Int).SetString(request.Amount, 10)
 		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid amount format"})
+			controllers.RespondInvalidParams(c, "invalid amount format")
 			return
 		}
 
 		// 创建 DepositController 实例并调用 DepositToAddress 方法
-		txHash, err := depositController.DepositToAddress(address, amount)
+		txHash, status, err := depositController.DepositToAddress(address, amount, request.Wait)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			bundlerErr := controllers.AsBundlerError(err)
+			c.JSON(bundlerErr.HTTPStatus(), gin.H{"error": bundlerErr.Message, "code": bundlerErr.Code, "transactionHash": txHash})
 			return
 		}
 
+		if status != nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Deposit to address confirmed", "transactionHash": txHash, "status": status.Status, "totalDeposit": status.TotalDeposit})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "Deposit to address completed", "transactionHash": txHash})
 	})
+
+	r.GET("/deposit/status", func(c *gin.Context) {
+		var request struct {
+			TxHash string `form:"txHash"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		txHash, err := hexutil.Decode(request.TxHash)
+		if err != nil || len(txHash) != common.HashLength {
+			controllers.RespondInvalidParams(c, "invalid txHash")
+			return
+		}
+
+		status, err := depositController.GetDepositStatus(common.BytesToHash(txHash))
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	})
+
+	r.POST("/deposit/withdraw", func(c *gin.Context) {
+		var request struct {
+			WithdrawAddress string `json:"withdrawAddress"`
+			Amount          string `json:"amount"`
+			Wait            bool   `json:"wait"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.WithdrawAddress) {
+			controllers.RespondInvalidParams(c, "invalid withdrawAddress")
+			return
+		}
+		amount, ok := new(big.Int).SetString(request.Amount, 10)
+		if !ok {
+			controllers.RespondInvalidParams(c, "invalid amount format")
+			return
+		}
+
+		txHash, status, err := depositController.WithdrawTo(common.HexToAddress(request.WithdrawAddress), amount, request.Wait)
+		respondStakeTransaction(c, "Withdraw", txHash, status, err)
+	})
+
+	r.GET("/deposit/info", func(c *gin.Context) {
+		var request struct {
+			Address string `form:"address"`
+		}
+		if err := c.ShouldBindQuery(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.Address) {
+			controllers.RespondInvalidParams(c, "invalid address")
+			return
+		}
+		address := common.HexToAddress(request.Address)
+
+		info, err := depositController.GetDepositInfo(address)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		balance, err := depositController.BalanceOf(address)
+		if err != nil {
+			controllers.RespondError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"address": address.Hex(), "balance": balance.String(), "depositInfo": info})
+	})
+
+	r.POST("/stake/add", func(c *gin.Context) {
+		var request struct {
+			UnstakeDelaySec uint32 `json:"unstakeDelaySec"`
+			Amount          string `json:"amount"`
+			Wait            bool   `json:"wait"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		amount, ok := new(big.Int).SetString(request.Amount, 10)
+		if !ok {
+			controllers.RespondInvalidParams(c, "invalid amount format")
+			return
+		}
+
+		txHash, status, err := depositController.AddStake(request.UnstakeDelaySec, amount, request.Wait)
+		respondStakeTransaction(c, "AddStake", txHash, status, err)
+	})
+
+	r.POST("/stake/unlock", func(c *gin.Context) {
+		// 请求体可以为空，只有需要等待确认时才传 {"wait": true}
+		var request struct {
+			Wait bool `json:"wait"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				controllers.RespondInvalidParams(c, "%v", err)
+				return
+			}
+		}
+
+		txHash, status, err := depositController.UnlockStake(request.Wait)
+		respondStakeTransaction(c, "UnlockStake", txHash, status, err)
+	})
+
+	r.POST("/stake/withdraw", func(c *gin.Context) {
+		var request struct {
+			WithdrawAddress string `json:"withdrawAddress"`
+			Wait            bool   `json:"wait"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			controllers.RespondInvalidParams(c, "%v", err)
+			return
+		}
+
+		if !common.IsHexAddress(request.WithdrawAddress) {
+			controllers.RespondInvalidParams(c, "invalid withdrawAddress")
+			return
+		}
+
+		txHash, status, err := depositController.WithdrawStake(common.HexToAddress(request.WithdrawAddress), request.Wait)
+		respondStakeTransaction(c, "WithdrawStake", txHash, status, err)
+	})
+}
+
+// respondStakeTransaction 返回质押或提取交易的结果，与 /deposit 一致：等待失败时仍带上交易哈希，等待成功时返回解析出的状态
+func respondStakeTransaction(c *gin.Context, name string, txHash string, status *models.StakeStatus, err error) {
+	if err != nil {
+		bundlerErr := controllers.AsBundlerError(err)
+		response := gin.H{"error": bundlerErr.Message, "code": bundlerErr.Code}
+		if txHash != "" {
+			response["transactionHash"] = txHash
+		}
+		c.JSON(bundlerErr.HTTPStatus(), response)
+		return
+	}
+
+	if status != nil {
+		c.JSON(http.StatusOK, gin.H{"message": name + " transaction confirmed", "transactionHash": txHash, "result": status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": name + " transaction sent", "transactionHash": txHash})
 }